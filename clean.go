@@ -8,10 +8,11 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/benjaminheng/md-code-renderer/render"
 	"github.com/spf13/cobra"
 )
 
-var renderedImageFilenameRegexp = regexp.MustCompile(`render-.{32}\.(svg|png)`)
+var renderedImageFilenameRegexp = regexp.MustCompile(`^render-[0-9a-f]{32}(-dark)?\.(svg|png|jpeg|pdf|webp)$`)
 
 func NewCleanCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -26,50 +27,102 @@ func NewCleanCmd() *cobra.Command {
 		},
 		RunE: cleanCmd,
 	}
-	cmd.Flags().StringVar(&config.Clean.ImageDir, "image-dir", "", "(required) Directory containing images")
-	cmd.MarkFlagRequired("image-dir")
+	cmd.Flags().StringVar(&config.Clean.ImageDir, "image-dir", "", "Directory containing images. May be a template evaluated per input file, as with render's --output-dir. If not specified, the directory containing each input file is used.")
 	return cmd
 }
 
 func cleanCmd(cmd *cobra.Command, args []string) error {
-	// Collect all file contents
+	// Collect all file contents, and the image directories they use
 	var allContent string
+	var imageDirs []string
+	seenImageDirs := make(map[string]bool)
 	for _, v := range args {
 		b, err := os.ReadFile(v)
 		if err != nil {
 			return err
 		}
 		allContent += "\n" + string(b)
+
+		imageDir, err := resolveOutputDir(v, config.Clean.ImageDir)
+		if err != nil {
+			return err
+		}
+		if !seenImageDirs[imageDir] {
+			seenImageDirs[imageDir] = true
+			imageDirs = append(imageDirs, imageDir)
+		}
 	}
 
-	// Collect files to remove
-	var filesToRemove []string
-	entries, err := os.ReadDir(config.Clean.ImageDir)
+	for _, imageDir := range imageDirs {
+		err := cleanImageDir(imageDir, allContent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanImageDir removes generated images in imageDir that are not linked to
+// in content. Generated images are those recorded in the directory's
+// manifest, or those with the default render-{hash} filenames.
+func cleanImageDir(imageDir string, content string) error {
+	entries, err := os.ReadDir(imageDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	manifest, err := render.ReadManifest(imageDir)
+	if err != nil {
+		return err
+	}
+
+	// Collect files to remove
+	var filesToRemove []string
 	for _, v := range entries {
 		if v.IsDir() {
 			continue
 		}
-		if !renderedImageFilenameRegexp.MatchString(v.Name()) {
+		if !renderedImageFilenameRegexp.MatchString(v.Name()) && !manifest.Contains(v.Name()) {
 			continue
 		}
-		// This is not efficient, since we are iterating through the
-		// contents of all files for each image being checked.
-		// Candidate for optimization later.
-		if !strings.Contains(allContent, v.Name()) {
-			filesToRemove = append(filesToRemove, path.Join(config.Clean.ImageDir, v.Name()))
+		if !isImageReferenced(content, v.Name()) {
+			filesToRemove = append(filesToRemove, v.Name())
 		}
 	}
 
 	// Remove files
 	for _, v := range filesToRemove {
-		err := os.Remove(v)
+		filePath := path.Join(imageDir, v)
+		err := os.Remove(filePath)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Removed orphaned file %s\n", v)
+		manifest.Remove(v)
+		fmt.Printf("Removed orphaned file %s\n", filePath)
 	}
-	return nil
+
+	// Drop entries for files that no longer exist
+	for _, v := range manifest.Files {
+		if _, err := os.Stat(path.Join(imageDir, v)); os.IsNotExist(err) {
+			manifest.Remove(v)
+		}
+	}
+	return manifest.Write(imageDir)
+}
+
+// isImageReferenced returns whether content links to the image, or to an
+// image rendered from the same code block in another format.
+func isImageReferenced(content string, fileName string) bool {
+	// This is not efficient, since we are iterating through the contents of
+	// all files for each image being checked. Candidate for optimization
+	// later.
+	stem := strings.TrimSuffix(fileName, path.Ext(fileName))
+	for _, v := range render.Formats {
+		if strings.Contains(content, stem+"."+v) {
+			return true
+		}
+	}
+	return strings.Contains(content, fileName)
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+)
+
+func TestCleanCmd(t *testing.T) {
+	const (
+		used   = "render-82682d8f229ac783001529cc84b0b85b.svg"
+		unused = "render-04579f4a9b61bbc54c6661af818353c5.svg"
+	)
+	tests := []struct {
+		name     string
+		imageDir string
+		images   []string // Images present before cleaning
+		manifest string   // Manifest in the docs directory, if set
+		want     []string // Images remaining after cleaning
+	}{
+		{
+			name:   "default",
+			images: []string{"docs/" + used, "docs/" + unused, "docs/photo.svg"},
+			want:   []string{"docs/photo.svg", "docs/" + used},
+		},
+		{
+			name:   "dark variants",
+			images: []string{"docs/render-82682d8f229ac783001529cc84b0b85b-dark.svg", "docs/render-04579f4a9b61bbc54c6661af818353c5-dark.svg", "docs/render-notahash-dark.svg"},
+			want:   []string{"docs/render-notahash-dark.svg"},
+		},
+		{
+			name:     "image directory",
+			imageDir: "assets",
+			images:   []string{"assets/" + used, "assets/" + unused, "docs/" + unused},
+			want:     []string{"assets/" + used, "docs/" + unused},
+		},
+		{
+			name:     "image directory template",
+			imageDir: "{{.Dir}}/assets/{{.Stem}}",
+			images:   []string{"docs/assets/doc/" + used, "docs/assets/doc/" + unused, "docs/assets/other/" + unused},
+			want:     []string{"docs/assets/doc/" + used, "docs/assets/other/" + unused},
+		},
+		{
+			name:     "manifest",
+			images:   []string{"docs/doc-1.svg", "docs/doc-2.svg", "docs/photo.svg"},
+			manifest: `{"files": ["doc-1.svg", "doc-2.svg", "doc-3.svg"]}`,
+			want:     []string{"docs/doc-1.svg", "docs/photo.svg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			writeFile(t, "docs/doc.md", "![diagram]("+used+")\n![diagram](doc-1.svg)\n")
+			if tt.manifest != "" {
+				writeFile(t, "docs/"+render.ManifestFilename, tt.manifest)
+			}
+			for _, v := range tt.images {
+				writeFile(t, v, "<svg/>")
+			}
+			config.Clean.ImageDir = tt.imageDir
+			defer func() { config.Clean.ImageDir = "" }()
+
+			err := cleanCmd(nil, []string{"docs/doc.md"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			filepath.Walk(".", func(filePath string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && filepath.Ext(filePath) == ".svg" {
+					got = append(got, filepath.ToSlash(filePath))
+				}
+				return nil
+			})
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remaining images = %v, want %v", got, tt.want)
+			}
+			if tt.manifest != "" {
+				manifest, err := render.ReadManifest("docs")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := []string{"doc-1.svg"}; !reflect.DeepEqual(manifest.Files, want) {
+					t.Errorf("manifest files = %v, want %v", manifest.Files, want)
+				}
+			}
+		})
+	}
+}
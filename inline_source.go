@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewInlineSourceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inline-source [files...]",
+		Short: "Move code blocks in the replace mode back into markdown files for editing",
+		Long:  `Move code blocks in the replace mode back into markdown files from their sidecar files, so that they can be edited. Files are updated in place. The code blocks are moved to sidecar files again when they are next rendered. Sidecar files that are no longer referenced are removed by clean.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: inlineSourceCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func inlineSourceCmd(cmd *cobra.Command, args []string) error {
+	for _, v := range args {
+		err := inlineSourceFile(v, config.Render)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("process file %s", v))
+		}
+	}
+	return nil
+}
+
+func inlineSourceFile(filePath string, cfg RenderConfig) error {
+	err := validateFileExists(filePath)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("read file %s", filePath))
+	}
+	inputFileContent := string(b)
+
+	opts, err := cfg.processOptions(filePath)
+	if err != nil {
+		return err
+	}
+	outputContent, err := render.InlineSources(inputFileContent, opts)
+	if err != nil {
+		return err
+	}
+	if inputFileContent == outputContent {
+		return nil
+	}
+	err = os.WriteFile(filePath, []byte(outputContent), 0666)
+	if err != nil {
+		return errors.Wrap(err, "write file")
+	}
+	fmt.Printf("Inlined sources in %s\n", filePath)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInlineSourceFile(t *testing.T) {
+	const (
+		image = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		begin = "<!-- md-code-renderer:begin mode=replace hash=82682d8f -->\n"
+		end   = "\n<!-- md-code-renderer:end -->"
+	)
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			name: "replaced",
+			doc:  begin + image + "\n\n<!-- md-code-renderer:source assets/diagram-82682d8f.dot dot render{\"mode\": \"replace\"} -->" + end,
+			want: begin + image + "\n\n```dot render{\"mode\": \"replace\"}\ndigraph { a -> b }\n```" + end,
+		},
+		{
+			name: "already inlined",
+			doc:  begin + image + "\n\n```dot render{\"mode\": \"replace\"}\ndigraph { a -> b }\n```" + end,
+			want: begin + image + "\n\n```dot render{\"mode\": \"replace\"}\ndigraph { a -> b }\n```" + end,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			writeFile(t, "docs/doc.md", tt.doc)
+			writeFile(t, "docs/assets/diagram-82682d8f.dot", "digraph { a -> b }\n")
+
+			err := inlineSourceFile("docs/doc.md", RenderConfig{Languages: "dot", DefaultFormat: "svg"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, "docs/doc.md"); got != tt.want {
+				t.Errorf("file =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
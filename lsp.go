@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewLSPCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lsp",
+		Short: "Run a Language Server Protocol server over stdio",
+		Long:  `Runs a Language Server Protocol server over stdio. Stale and broken code blocks are reported as diagnostics, and a code action is provided to render a code block.`,
+		Args:  cobra.NoArgs,
+		RunE:  lspCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func lspCmd(cmd *cobra.Command, args []string) error {
+	s := &lspServer{
+		config:       config.Render,
+		out:          os.Stdout,
+		documents:    make(map[string]string),
+		renderErrors: make(map[string]string),
+	}
+	return s.run(os.Stdin)
+}
+
+const lspRenderCommand = "md-code-renderer.render"
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+)
+
+// lspServer is a minimal language server. Documents are synced in full on
+// each change, and rendering is only done when explicitly requested, or when
+// a document is saved.
+type lspServer struct {
+	config RenderConfig
+
+	mu           sync.Mutex
+	out          io.Writer
+	nextID       int
+	documents    map[string]string // URI -> content
+	renderErrors map[string]string // Code block hash -> renderer error
+}
+
+func (s *lspServer) run(in io.Reader) error {
+	reader := bufio.NewReader(in)
+	for {
+		msg, err := readLSPMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil || msg.Method == "" {
+			// Notifications and responses to our own requests
+			// need no reply.
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", msg.Method, err)
+			}
+			continue
+		}
+		response := lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		if err != nil {
+			response.Result = nil
+			response.Error = &lspError{Code: -32603, Message: err.Error()}
+		} else if result == nil {
+			// The result must be present on success, even if null
+			response.Result = json.RawMessage("null")
+		}
+		s.send(response)
+	}
+}
+
+func (s *lspServer) handle(msg *lspMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1, // Full
+					"save":      true,
+				},
+				"codeActionProvider": true,
+				"hoverProvider":      true,
+				"executeCommandProvider": map[string]interface{}{
+					"commands": []string{lspRenderCommand},
+				},
+			},
+			"serverInfo": map[string]string{"name": "md-code-renderer"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.setDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.publishDiagnostics(params.TextDocument.URI, false)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) > 0 {
+			s.setDocument(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		s.publishDiagnostics(params.TextDocument.URI, false)
+	case "textDocument/didSave":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.publishDiagnostics(params.TextDocument.URI, true)
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		delete(s.documents, params.TextDocument.URI)
+		s.mu.Unlock()
+	case "textDocument/codeAction":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Range lspRange `json:"range"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.codeActions(params.TextDocument.URI, params.Range)
+	case "workspace/executeCommand":
+		var params struct {
+			Command   string            `json:"command"`
+			Arguments []json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if params.Command != lspRenderCommand || len(params.Arguments) != 2 {
+			return nil, fmt.Errorf("unsupported command: %s", params.Command)
+		}
+		var uri string
+		var lineIndex int
+		if err := json.Unmarshal(params.Arguments[0], &uri); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(params.Arguments[1], &lineIndex); err != nil {
+			return nil, err
+		}
+		return nil, s.renderBlock(uri, lineIndex)
+	case "textDocument/hover":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Position lspPosition `json:"position"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params.TextDocument.URI, params.Position)
+	}
+	return nil, nil
+}
+
+func (s *lspServer) setDocument(uri string, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[uri] = text
+}
+
+func (s *lspServer) chunks(uri string) ([]*render.Chunk, []string, error) {
+	s.mu.Lock()
+	text, ok := s.documents[uri]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown document: %s", uri)
+	}
+	opts, err := s.config.processOptions(lspDocumentPath(uri))
+	if err != nil {
+		return nil, nil, err
+	}
+	chunks, err := render.ParseChunks(text, opts)
+	return chunks, strings.Split(text, "\n"), err
+}
+
+// publishDiagnostics reports code blocks that are invalid or stale. If
+// runRenderer is set, stale code blocks are also rendered without writing
+// the result, so that errors from the renderer can be reported.
+func (s *lspServer) publishDiagnostics(uri string, runRenderer bool) {
+	diagnostics := []lspDiagnostic{}
+	chunks, lines, err := s.chunks(uri)
+	if parseErr, ok := err.(*render.ParseError); ok {
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    lspLineRange(lines, parseErr.LineIndex),
+			Severity: lspSeverityError,
+			Source:   "md-code-renderer",
+			Message:  parseErr.Err.Error(),
+		})
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", uri, err)
+		return
+	}
+
+	for _, chunk := range chunks {
+		if !chunk.ShouldRender() {
+			continue
+		}
+		hash := chunk.HashContent()
+		if runRenderer {
+			_, err := chunk.RenderContent()
+			s.mu.Lock()
+			if err != nil {
+				s.renderErrors[hash] = err.Error()
+			} else {
+				delete(s.renderErrors, hash)
+			}
+			s.mu.Unlock()
+		}
+
+		s.mu.Lock()
+		renderErr, hasRenderErr := s.renderErrors[hash]
+		s.mu.Unlock()
+		diagnostic := lspDiagnostic{
+			Range:    lspLineRange(lines, chunk.CodeBlockIndex),
+			Severity: lspSeverityWarning,
+			Source:   "md-code-renderer",
+			Message:  "Rendered image is out of date",
+		}
+		if chunk.RenderedHash == "" {
+			diagnostic.Message = "Code block has not been rendered"
+		}
+		if hasRenderErr {
+			diagnostic.Severity = lspSeverityError
+			diagnostic.Message = renderErr
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+// codeActions offers to render the stale code blocks within the range. The
+// render itself happens when the command is executed, since editors request
+// code actions frequently.
+func (s *lspServer) codeActions(uri string, r lspRange) (interface{}, error) {
+	actions := []interface{}{}
+	chunks, _, err := s.chunks(uri)
+	if err != nil {
+		return actions, nil
+	}
+	for _, chunk := range chunks {
+		if !chunk.ShouldRender() {
+			continue
+		}
+		if chunk.EndLineIndex < r.Start.Line || chunk.StartLineIndex > r.End.Line {
+			continue
+		}
+		actions = append(actions, map[string]interface{}{
+			"title": "Render this block",
+			"kind":  "quickfix",
+			"command": map[string]interface{}{
+				"title":     "Render this block",
+				"command":   lspRenderCommand,
+				"arguments": []interface{}{uri, chunk.CodeBlockIndex},
+			},
+		})
+	}
+	return actions, nil
+}
+
+// renderBlock renders the code block starting at lineIndex, and asks the
+// client to apply the rewritten chunk as a text edit.
+func (s *lspServer) renderBlock(uri string, lineIndex int) error {
+	chunks, lines, err := s.chunks(uri)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable || chunk.CodeBlockIndex != lineIndex {
+			continue
+		}
+		// Copy the chunk's lines, since they may share storage with
+		// the document's lines.
+		startLineIndex, endLineIndex := chunk.StartLineIndex, chunk.EndLineIndex
+		chunk.Lines = append([]string(nil), chunk.Lines...)
+		_, err := s.renderChunk(uri, chunk)
+		if err != nil {
+			s.mu.Lock()
+			s.renderErrors[chunk.HashContent()] = err.Error()
+			s.mu.Unlock()
+			s.publishDiagnostics(uri, false)
+			return errors.Wrap(err, "render chunk")
+		}
+
+		edit := lspTextEdit{
+			Range: lspRange{
+				Start: lspPosition{Line: startLineIndex},
+				End:   lspPosition{Line: endLineIndex, Character: lspLineLength(lines[endLineIndex])},
+			},
+			NewText: strings.Join(chunk.Lines, "\n"),
+		}
+		s.request("workspace/applyEdit", map[string]interface{}{
+			"label": "Render this block",
+			"edit": map[string]interface{}{
+				"changes": map[string][]lspTextEdit{uri: {edit}},
+			},
+		})
+		return nil
+	}
+	return fmt.Errorf("no renderable code block at line %d", lineIndex+1)
+}
+
+func (s *lspServer) renderChunk(uri string, chunk *render.Chunk) (string, error) {
+	opts, err := s.config.processOptions(lspDocumentPath(uri))
+	if err != nil {
+		return "", err
+	}
+	return opts.RenderChunk(chunk)
+}
+
+// hover shows the path of the image rendered from the code block under the
+// cursor.
+func (s *lspServer) hover(uri string, position lspPosition) (interface{}, error) {
+	chunks, _, err := s.chunks(uri)
+	if err != nil {
+		return nil, nil
+	}
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable || position.Line < chunk.StartLineIndex || position.Line > chunk.EndLineIndex {
+			continue
+		}
+		outputDir, err := s.lspOutputDir(uri)
+		if err != nil {
+			return nil, err
+		}
+		imagePath := path.Join(outputDir, chunk.FileName())
+		status := "up to date"
+		if chunk.RenderedHash == "" && chunk.ShouldRender() {
+			status = "not rendered"
+		} else if chunk.ShouldRender() {
+			status = "out of date"
+		}
+		return map[string]interface{}{
+			"contents": map[string]string{
+				"kind":  "markdown",
+				"value": fmt.Sprintf("Rendered image: `%s` (%s)", imagePath, status),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// lspOutputDir returns the directory images are rendered to for the
+// document.
+func (s *lspServer) lspOutputDir(uri string) (string, error) {
+	return resolveOutputDir(lspDocumentPath(uri), s.config.OutputDir)
+}
+
+// lspDocumentPath returns the path of the document. Documents that are not
+// files are treated as if they are in the working directory.
+func lspDocumentPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "untitled"
+	}
+	return u.Path
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	b, _ := json.Marshal(params)
+	s.send(lspMessage{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+func (s *lspServer) request(method string, params interface{}) {
+	s.mu.Lock()
+	s.nextID++
+	id := json.RawMessage(strconv.Itoa(s.nextID))
+	s.mu.Unlock()
+	b, _ := json.Marshal(params)
+	s.send(lspMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: b})
+}
+
+func (s *lspServer) send(msg lspMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encode message: %s\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+// readLSPMessage reads a message framed with a Content-Length header.
+func readLSPMessage(reader *bufio.Reader) (*lspMessage, error) {
+	contentLength := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return nil, errors.Wrap(err, "parse content length")
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, errors.New("missing content length")
+	}
+	b := make([]byte, contentLength)
+	_, err := io.ReadFull(reader, b)
+	if err != nil {
+		return nil, err
+	}
+	var msg lspMessage
+	err = json.Unmarshal(b, &msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode message")
+	}
+	return &msg, nil
+}
+
+func lspLineRange(lines []string, lineIndex int) lspRange {
+	var length int
+	if lineIndex < len(lines) {
+		length = lspLineLength(lines[lineIndex])
+	}
+	return lspRange{
+		Start: lspPosition{Line: lineIndex},
+		End:   lspPosition{Line: lineIndex, Character: length},
+	}
+}
+
+// lspLineLength returns the length of a line in UTF-16 code units, which is
+// what LSP positions are measured in.
+func lspLineLength(line string) int {
+	return len(utf16.Encode([]rune(line)))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lspMessages reads the messages written by the server.
+func lspMessages(t *testing.T, out *bytes.Buffer) []*lspMessage {
+	t.Helper()
+	var messages []*lspMessage
+	reader := bufio.NewReader(out)
+	for reader.Buffered() > 0 || out.Len() > 0 {
+		msg, err := readLSPMessage(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	const (
+		uri   = "file:///docs/doc.md"
+		image = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+	)
+	tests := []struct {
+		name        string
+		text        string
+		runRenderer bool
+		want        []lspDiagnostic
+	}{
+		{
+			name: "up to date",
+			text: "# Doc\n\n" + image + "\n\n```dot render\ndigraph { a -> b }\n```",
+			want: []lspDiagnostic{},
+		},
+		{
+			name: "not rendered",
+			text: "# Doc\n\n```dot render\ndigraph { a -> b }\n```",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 2}, End: lspPosition{Line: 2, Character: 13}},
+				Severity: lspSeverityWarning,
+				Source:   "md-code-renderer",
+				Message:  "Code block has not been rendered",
+			}},
+		},
+		{
+			name: "out of date",
+			text: "# Doc\n\n" + image + "\n\n```dot render\ndigraph { a -> c }\n```",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 4}, End: lspPosition{Line: 4, Character: 13}},
+				Severity: lspSeverityWarning,
+				Source:   "md-code-renderer",
+				Message:  "Rendered image is out of date",
+			}},
+		},
+		{
+			name: "invalid options",
+			text: "# Doc\n\n```dot render{\"mode\": \"bogus\"}\ndigraph { a -> b }\n```",
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 2}, End: lspPosition{Line: 2, Character: 30}},
+				Severity: lspSeverityError,
+				Source:   "md-code-renderer",
+				Message:  `unsupported mode "bogus"`,
+			}},
+		},
+		{
+			name:        "renderer error",
+			text:        "# Doc\n\n```dot render\ndigraph { a -> b }\n```",
+			runRenderer: true,
+			want: []lspDiagnostic{{
+				Range:    lspRange{Start: lspPosition{Line: 2}, End: lspPosition{Line: 2, Character: 13}},
+				Severity: lspSeverityError,
+				Source:   "md-code-renderer",
+				Message:  "render graphviz: exit status 1: syntax error",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failingRenderers(t, "syntax error")
+			var out bytes.Buffer
+			s := &lspServer{
+				config:       RenderConfig{Languages: "dot", DefaultFormat: "svg"},
+				out:          &out,
+				documents:    make(map[string]string),
+				renderErrors: make(map[string]string),
+			}
+			s.setDocument(uri, tt.text)
+			s.publishDiagnostics(uri, tt.runRenderer)
+
+			messages := lspMessages(t, &out)
+			if len(messages) != 1 || messages[0].Method != "textDocument/publishDiagnostics" {
+				t.Fatalf("messages = %+v, want diagnostics", messages)
+			}
+			var params struct {
+				URI         string          `json:"uri"`
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			}
+			err := json.Unmarshal(messages[0].Params, &params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(params.Diagnostics)
+			want, _ := json.Marshal(tt.want)
+			if params.URI != uri || string(got) != string(want) {
+				t.Errorf("diagnostics for %s = %s, want %s", params.URI, got, want)
+			}
+		})
+	}
+}
+
+func TestLSPRun(t *testing.T) {
+	var in bytes.Buffer
+	for i, v := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///doc.md","text":"` + "```dot render\\ndigraph { a -> b }\\n```" + `"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///doc.md"},"position":{"line":1,"character":0}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/codeAction","params":{"textDocument":{"uri":"file:///doc.md"},"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		if i == 0 {
+			// Headers are case-insensitive
+			fmt.Fprintf(&in, "content-length: %d\r\n\r\n%s", len(v), v)
+			continue
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(v), v)
+	}
+	var out bytes.Buffer
+	s := &lspServer{
+		config:       RenderConfig{Languages: "dot", DefaultFormat: "svg"},
+		out:          &out,
+		documents:    make(map[string]string),
+		renderErrors: make(map[string]string),
+	}
+	err := s.run(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Responses are checked as written, since results must be present
+	// even if null
+	got := out.String()
+	want := []string{
+		`"id":1,"result":{"capabilities":`,
+		`"method":"textDocument/publishDiagnostics"`,
+		`"id":2,"result":{"contents":{"kind":"markdown","value":"Rendered image: ` + "`/render-82682d8f229ac783001529cc84b0b85b.svg`" + ` (not rendered)"}}`,
+		`"id":3,"result":[{"command":{"arguments":["file:///doc.md",0],"command":"md-code-renderer.render","title":"Render this block"},"kind":"quickfix","title":"Render this block"}]`,
+		`"id":4,"result":null`,
+	}
+	if n := len(lspMessages(t, bytes.NewBufferString(got))); n != len(want) {
+		t.Fatalf("got %d messages, want %d:\n%s", n, len(want), got)
+	}
+	rest := got
+	for _, v := range want {
+		i := strings.Index(rest, v)
+		if i < 0 {
+			t.Fatalf("output doesn't contain %s in order:\n%s", v, got)
+		}
+		rest = rest[i+len(v):]
+	}
+}
+
+func TestLSPLineLength(t *testing.T) {
+	tests := []struct {
+		line string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"é", 1},
+		{"😀", 2},
+	}
+	for _, tt := range tests {
+		if got := lspLineLength(tt.line); got != tt.want {
+			t.Errorf("lspLineLength(%q) = %d, want %d", tt.line, got, tt.want)
+		}
+	}
+}
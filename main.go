@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/benjaminheng/md-code-renderer/render"
 	"github.com/spf13/cobra"
 )
 
@@ -11,11 +14,43 @@ type Config struct {
 	Clean struct {
 		ImageDir string
 	}
-	Render struct {
-		OutputDir  string // Directory to output rendered files to
-		Languages  string // Languages to render, comma separated
-		LinkPrefix string // Prefix to use when linking to rendered files
+	Render RenderConfig
+	Watch  struct {
+		Debounce     time.Duration // Time to wait for further changes before re-rendering
+		Poll         bool          // Poll for changes instead of using filesystem notifications
+		PollInterval time.Duration
 	}
+	Serve struct {
+		Addr string // Address for the preview server to listen on
+	}
+}
+
+type RenderConfig struct {
+	OutputDir        string               // Directory to output rendered files to
+	Languages        string               // Languages to render, comma separated
+	LinkPrefix       string               // Prefix to use when linking to rendered files
+	FilenameTemplate string               // Template for the filenames of rendered files
+	DefaultFormat    string               // Format to render to, if not specified by the code block
+	Formats          []string             // Formats to render to, if not specified by the code block. Overrides DefaultFormat.
+	Dark             bool                 // Whether to render dark variants, if not specified by the code block
+	Inline           string               // How to embed images in the markdown file, if not specified by the code block
+	SVG              render.SVGOptions    // Post-processing of rendered SVGs
+	Raster           render.RasterOptions // Options for raster images, if not specified by the code block
+	CaptionStyle     string               // How captions of figures are written: italic or figure
+	InputFiles       []string             // Markdown files rendered together, listed by lists of figures that span the input set
+	Templates        string               // Path to a JSON file defining custom modes
+	LinkStyle        string               // Syntax of the images that link to rendered files
+}
+
+func (c RenderConfig) languages() []string {
+	return strings.Split(c.Languages, ",")
+}
+
+func (c RenderConfig) defaultFormats() []string {
+	if len(c.Formats) > 0 {
+		return c.Formats
+	}
+	return []string{c.DefaultFormat}
 }
 
 var config Config
@@ -33,6 +68,11 @@ func NewRootCmd() *cobra.Command {
 
 	cmd.AddCommand(NewRenderCmd())
 	cmd.AddCommand(NewCleanCmd())
+	cmd.AddCommand(NewWatchCmd())
+	cmd.AddCommand(NewServeCmd())
+	cmd.AddCommand(NewPandocFilterCmd())
+	cmd.AddCommand(NewLSPCmd())
+	cmd.AddCommand(NewInlineSourceCmd())
 	return cmd
 }
 
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeRenderers puts stand-ins for the renderers on the PATH, which output a
+// fixed SVG. Returns a function counting the renderer invocations so far.
+func fakeRenderers(t *testing.T) (calls func() int) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake renderers are shell scripts")
+	}
+	dir := t.TempDir()
+	callsPath := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\ncat >/dev/null\necho x >>" + callsPath + "\necho '<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>'\n"
+	for _, v := range []string{"dot", "plantuml", "pikchr"} {
+		err := os.WriteFile(filepath.Join(dir, v), []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func() int {
+		b, _ := os.ReadFile(callsPath)
+		return strings.Count(string(b), "x")
+	}
+}
+
+// failingRenderers puts stand-ins for the renderers on the PATH, which fail
+// with message on stderr.
+func failingRenderers(t *testing.T, message string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake renderers are shell scripts")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat >/dev/null\necho '" + message + "' >&2\nexit 1\n"
+	for _, v := range []string{"dot", "plantuml", "pikchr"} {
+		err := os.WriteFile(filepath.Join(dir, v), []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// chdir changes the working directory to dir until the test finishes.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, filePath string) string {
+	t.Helper()
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
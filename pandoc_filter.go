@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewPandocFilterCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pandoc-filter [format]",
+		Short: "Render code blocks as a Pandoc JSON filter",
+		Long: `Reads a Pandoc JSON AST from stdin, renders code blocks marked with the "render" class, and writes the modified AST to stdout. Options are read from the code block's attributes, e.g.:
+
+    ` + "```" + `{.dot .render mode="code-collapsed"}
+
+The target format may be given as an argument, as Pandoc does when running filters. For HTML formats, the collapsed modes are wrapped in <details> elements, and the side-by-side mode in a <table>. Code blocks with a caption or an id are wrapped in Figure elements.
+
+Paths are relative to the working directory, as Pandoc's input file is unknown. --link-style and --caption-style are not supported, as Pandoc writes the images and figures itself.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: pandocFilterCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func pandocFilterCmd(cmd *cobra.Command, args []string) error {
+	// Pandoc passes the target format as the first argument
+	var format string
+	if len(args) > 0 {
+		format = args[0]
+	}
+	// Pandoc's output location is unknown to us, so links are relative
+	// to the working directory.
+	outputDir, err := resolveOutputDir("pandoc", config.Render.OutputDir)
+	if err != nil {
+		return err
+	}
+	linkPrefix, err := resolveLinkPrefix(".", outputDir, config.Render.LinkPrefix)
+	if err != nil {
+		return err
+	}
+	for _, v := range []string{"link-style", "caption-style"} {
+		if cmd.Flags().Changed(v) {
+			return errors.Errorf("--%s is not supported by the pandoc filter", v)
+		}
+	}
+	err = render.FormatList(config.Render.defaultFormats()).Validate()
+	if err != nil {
+		return errors.Wrap(err, "validate default formats")
+	}
+	var filenameTemplate *template.Template
+	if config.Render.FilenameTemplate != "" {
+		filenameTemplate, err = render.ParseFilenameTemplate(config.Render.FilenameTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	modeTemplates, err := config.Render.modeTemplates()
+	if err != nil {
+		return err
+	}
+	f := pandocFilter{
+		languages:        config.Render.languages(),
+		customModes:      modeTemplates,
+		outputDir:        outputDir,
+		linkPrefix:       linkPrefix,
+		filenameTemplate: filenameTemplate,
+		defaultFormats:   config.Render.defaultFormats(),
+		extraFormats:     config.Render.Formats,
+		dark:             config.Render.Dark,
+		inline:           config.Render.Inline,
+		svg:              config.Render.SVG,
+		raster:           config.Render.Raster,
+		html:             isPandocHTMLFormat(format),
+	}
+
+	decoder := json.NewDecoder(os.Stdin)
+	decoder.UseNumber()
+	var doc interface{}
+	err = decoder.Decode(&doc)
+	if err != nil {
+		return errors.Wrap(err, "decode pandoc AST")
+	}
+	doc, err = f.walk(doc)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	return errors.Wrap(encoder.Encode(doc), "encode pandoc AST")
+}
+
+// pandocFilter renders CodeBlock elements in a Pandoc JSON AST. The AST is
+// handled generically rather than through typed structs, so that elements we
+// don't care about pass through untouched regardless of the Pandoc version.
+type pandocFilter struct {
+	languages        []string
+	customModes      map[string]*template.Template // Laid out like the normal mode
+	outputDir        string
+	linkPrefix       string
+	filenameTemplate *template.Template
+	defaultFormats   []string
+	extraFormats     []string
+	dark             bool
+	inline           string
+	svg              render.SVGOptions
+	raster           render.RasterOptions
+	html             bool // Whether the output format supports raw HTML
+	index            int  // Number of code blocks rendered so far
+}
+
+// walk traverses the AST, replacing renderable code blocks with the blocks
+// for their render mode. Code blocks only appear in lists of blocks, so they
+// are expanded in place within the array containing them.
+func (f *pandocFilter) walk(node interface{}) (interface{}, error) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			newChild, err := f.walk(child)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = newChild
+		}
+		return v, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, child := range v {
+			if codeBlock, ok := child.(map[string]interface{}); ok && codeBlock["t"] == "CodeBlock" {
+				blocks, err := f.renderCodeBlock(codeBlock)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, blocks...)
+				continue
+			}
+			newChild, err := f.walk(child)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, newChild)
+		}
+		return result, nil
+	default:
+		return node, nil
+	}
+}
+
+// renderCodeBlock renders a CodeBlock element, which has the structure:
+//
+//	{"t": "CodeBlock", "c": [[id, [classes], [[key, value]]], text]}
+//
+// The returned blocks replace the code block in the AST. Code blocks that are
+// not marked for rendering are returned as-is.
+func (f *pandocFilter) renderCodeBlock(codeBlock map[string]interface{}) ([]interface{}, error) {
+	content, _ := codeBlock["c"].([]interface{})
+	if len(content) != 2 {
+		return []interface{}{codeBlock}, nil
+	}
+	attr, _ := content[0].([]interface{})
+	text, _ := content[1].(string)
+	if len(attr) != 3 {
+		return []interface{}{codeBlock}, nil
+	}
+	id, _ := attr[0].(string)
+	classes := pandocStrings(attr[1])
+	attributes, _ := attr[2].([]interface{})
+
+	// Determine whether the block should be rendered, and its language
+	var isRender bool
+	var language string
+	var keptClasses []string
+	for _, class := range classes {
+		if class == "render" {
+			isRender = true
+			continue
+		}
+		for _, v := range f.languages {
+			if class == v && language == "" {
+				language = class
+			}
+		}
+		keptClasses = append(keptClasses, class)
+	}
+	if !isRender || language == "" {
+		return []interface{}{codeBlock}, nil
+	}
+
+	renderOptions, keptAttributes, err := parsePandocRenderOptions(attributes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse render options")
+	}
+	err = renderOptions.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "validate render options")
+	}
+	if _, ok := f.customModes[renderOptions.Mode]; !ok && !isPandocBuiltinMode(renderOptions.Mode) {
+		return nil, errors.Errorf("unsupported mode %q", renderOptions.Mode)
+	}
+
+	f.index++
+	chunk := render.NewChunk(language, text, renderOptions)
+	chunk.DocumentName = "pandoc"
+	chunk.Index = f.index
+	chunk.FilenameTemplate = f.filenameTemplate
+	chunk.DefaultFormats = f.defaultFormats
+	chunk.ExtraFormats = f.extraFormats
+	chunk.DefaultDark = f.dark
+	chunk.DefaultInline = f.inline
+	chunk.SVGOptions = f.svg
+	chunk.RasterOptions = f.raster
+	// Pictures can only be expressed as raw HTML
+	if !f.html {
+		dark := false
+		chunk.RenderOptions.Dark = &dark
+	}
+	image, err := f.buildImage(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	// The code block is kept without the render class and options, so
+	// that Pandoc highlights it as a normal code block.
+	newCodeBlock := map[string]interface{}{
+		"t": "CodeBlock",
+		"c": []interface{}{
+			[]interface{}{id, pandocStringList(keptClasses), pandocList(keptAttributes)},
+			text,
+		},
+	}
+
+	switch renderOptions.Mode {
+	case "code-collapsed":
+		if f.html {
+			return []interface{}{
+				image,
+				buildPandocRawHTML("<details><summary>Source</summary>"),
+				newCodeBlock,
+				buildPandocRawHTML("</details>"),
+			}, nil
+		}
+		return []interface{}{image, newCodeBlock}, nil
+	case "image-collapsed":
+		if f.html {
+			return []interface{}{
+				newCodeBlock,
+				buildPandocRawHTML("<details><summary>Image</summary>"),
+				image,
+				buildPandocRawHTML("</details>"),
+			}, nil
+		}
+		return []interface{}{newCodeBlock, image}, nil
+	case "image-below":
+		return []interface{}{newCodeBlock, image}, nil
+	case "side-by-side":
+		if f.html {
+			return []interface{}{
+				buildPandocRawHTML("<table><tr><td>"),
+				newCodeBlock,
+				buildPandocRawHTML("</td><td>"),
+				image,
+				buildPandocRawHTML("</td></tr></table>"),
+			}, nil
+		}
+		return []interface{}{newCodeBlock, image}, nil
+	case "code-hidden", "replace":
+		return []interface{}{image}, nil
+	default:
+		return []interface{}{image, newCodeBlock}, nil
+	}
+}
+
+// buildImage renders the chunk's images and returns the block displaying
+// them. Figures are wrapped in a Figure block, with the caption.
+func (f *pandocFilter) buildImage(chunk *render.Chunk) (map[string]interface{}, error) {
+	image, err := f.buildImageBlock(chunk)
+	if err != nil {
+		return nil, err
+	}
+	if !chunk.IsFigure() {
+		return image, nil
+	}
+	// A figure's image is a Plain block rather than a paragraph
+	if image["t"] == "Para" {
+		image["t"] = "Plain"
+	}
+	return buildPandocFigure(chunk.FigureAnchor(), chunk.RenderOptions.Caption, image), nil
+}
+
+// buildImageBlock renders the chunk's images, and returns the block
+// displaying them: a paragraph containing the image, or raw HTML for pictures
+// and inline SVGs.
+func (f *pandocFilter) buildImageBlock(chunk *render.Chunk) (map[string]interface{}, error) {
+	fileName := chunk.FileName()
+	alt, title := chunk.AltText(), chunk.Title()
+	if inline := chunk.Inline(); inline != "" {
+		if inline == "svg" && chunk.Format() != "svg" {
+			return nil, errors.Errorf("inline svg requires the svg format, not %s", chunk.Format())
+		}
+		content, err := chunk.RenderContent()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("render code block %s", fileName))
+		}
+		// SVG elements can only be expressed as raw HTML, so other
+		// formats get a data URI instead
+		if inline == "svg" && f.html {
+			return buildPandocRawHTML(render.InlineSVG(content)), nil
+		}
+		if !chunk.HasDarkVariant() {
+			return buildPandocImage(alt, title, render.DataURI(fileName, content)), nil
+		}
+		darkContent, err := chunk.RenderDarkFormat(chunk.Format())
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("render code block %s", render.DarkFileName(fileName)))
+		}
+		return buildPandocRawHTML(render.BuildPicture(alt, title, render.DataURI(fileName, content), render.DataURI(fileName, darkContent))), nil
+	}
+
+	for _, format := range chunk.Formats() {
+		fileName := chunk.FileNameForFormat(format)
+		err := f.renderImage(chunk, fileName, format, false)
+		if err != nil {
+			return nil, err
+		}
+		if !chunk.HasDarkVariant() {
+			continue
+		}
+		err = f.renderImage(chunk, render.DarkFileName(fileName), format, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if chunk.HasDarkVariant() {
+		return buildPandocRawHTML(render.BuildPicture(alt, title, f.linkPrefix+fileName, f.linkPrefix+render.DarkFileName(fileName))), nil
+	}
+	return buildPandocImage(alt, title, f.linkPrefix+fileName), nil
+}
+
+// parsePandocRenderOptions reads the render options from a code block's
+// attributes, e.g. mode="code-collapsed" or scale="2". Options take the same
+// names as in the render{...} JSON of markdown code blocks. The attributes
+// that aren't render options are returned.
+func parsePandocRenderOptions(attributes []interface{}) (renderOptions render.RenderOptions, kept []interface{}, err error) {
+	for _, v := range attributes {
+		kv := pandocStrings(v)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "mode":
+			renderOptions.Mode = value
+		case "filename":
+			renderOptions.Filename = value
+		case "format":
+			renderOptions.Format = strings.Split(value, ",")
+		case "dark":
+			dark, err := strconv.ParseBool(value)
+			if err != nil {
+				return renderOptions, nil, errors.Errorf("invalid %s %q", key, value)
+			}
+			renderOptions.Dark = &dark
+		case "inline":
+			renderOptions.Inline = value
+		case "width":
+			renderOptions.Width = value
+		case "height":
+			renderOptions.Height = value
+		case "alt":
+			renderOptions.Alt = value
+		case "title":
+			renderOptions.Title = value
+		case "caption":
+			renderOptions.Caption = value
+		case "id":
+			renderOptions.ID = value
+		case "scale":
+			renderOptions.Scale, err = strconv.ParseFloat(value, 64)
+		case "dpi":
+			renderOptions.DPI, err = strconv.Atoi(value)
+		case "padding":
+			renderOptions.Padding, err = strconv.Atoi(value)
+		case "background":
+			renderOptions.Background = value
+		default:
+			kept = append(kept, v)
+		}
+		if err != nil {
+			return renderOptions, nil, errors.Errorf("invalid %s %q", key, value)
+		}
+	}
+	return renderOptions, kept, nil
+}
+
+func isPandocBuiltinMode(mode string) bool {
+	switch mode {
+	case "normal", "code-collapsed", "image-collapsed", "code-hidden", "image-below", "side-by-side", "replace":
+		return true
+	}
+	return false
+}
+
+// renderImage renders an image to the output directory, unless it is up to
+// date.
+func (f *pandocFilter) renderImage(chunk *render.Chunk, fileName string, format string, dark bool) error {
+	rendered, err := chunk.WriteImageIfChanged(f.outputDir, fileName, format, dark)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("render code block %s", fileName))
+	}
+	if rendered {
+		fmt.Fprintf(os.Stderr, "Rendered %s\n", fileName)
+	}
+	return nil
+}
+
+// buildPandocImage builds a paragraph containing an image:
+//
+//	{"t": "Para", "c": [{"t": "Image", "c": [attr, [alt], [url, title]]}]}
+func buildPandocImage(alt string, title string, link string) map[string]interface{} {
+	image := map[string]interface{}{
+		"t": "Image",
+		"c": []interface{}{
+			[]interface{}{"", []interface{}{}, []interface{}{}},
+			buildPandocInlines(alt),
+			[]interface{}{link, title},
+		},
+	}
+	return map[string]interface{}{
+		"t": "Para",
+		"c": []interface{}{image},
+	}
+}
+
+// buildPandocFigure builds a figure containing the block, with the caption:
+//
+//	{"t": "Figure", "c": [attr, [shortCaption, [Plain]], [block]]}
+func buildPandocFigure(id string, caption string, block map[string]interface{}) map[string]interface{} {
+	var captionBlocks []interface{}
+	if caption != "" {
+		captionBlocks = append(captionBlocks, map[string]interface{}{"t": "Plain", "c": buildPandocInlines(caption)})
+	}
+	return map[string]interface{}{
+		"t": "Figure",
+		"c": []interface{}{
+			[]interface{}{id, []interface{}{}, []interface{}{}},
+			[]interface{}{nil, pandocList(captionBlocks)},
+			[]interface{}{block},
+		},
+	}
+}
+
+// buildPandocInlines builds the inlines of text, as words separated by
+// spaces.
+func buildPandocInlines(text string) []interface{} {
+	var inlines []interface{}
+	for i, v := range strings.Fields(text) {
+		if i > 0 {
+			inlines = append(inlines, map[string]interface{}{"t": "Space"})
+		}
+		inlines = append(inlines, map[string]interface{}{"t": "Str", "c": v})
+	}
+	return pandocList(inlines)
+}
+
+func buildPandocRawHTML(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"t": "RawBlock",
+		"c": []interface{}{"html", content},
+	}
+}
+
+func isPandocHTMLFormat(format string) bool {
+	if strings.HasPrefix(format, "html") || strings.HasPrefix(format, "epub") {
+		return true
+	}
+	switch format {
+	case "revealjs", "slidy", "slideous", "s5", "dzslides", "gfm", "markdown", "commonmark":
+		return true
+	}
+	return false
+}
+
+func pandocStrings(node interface{}) []string {
+	list, _ := node.([]interface{})
+	var result []string
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func pandocStringList(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
+
+func pandocList(values []interface{}) []interface{} {
+	if values == nil {
+		return []interface{}{}
+	}
+	return values
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+)
+
+// pandocCodeBlock returns a CodeBlock element with the code "digraph { a -> b }".
+func pandocCodeBlock(classes string, attributes string) string {
+	return `{"t":"CodeBlock","c":[["",[` + classes + `],[` + attributes + `]],"digraph { a -> b }"]}`
+}
+
+func TestPandocFilterWalk(t *testing.T) {
+	const (
+		image   = `{"t":"Para","c":[{"t":"Image","c":[["",[],[]],[{"t":"Str","c":"Graphviz"},{"t":"Space"},{"t":"Str","c":"diagram"}],["render-82682d8f229ac783001529cc84b0b85b.svg",""]]}]}`
+		code    = `{"t":"CodeBlock","c":[["",["dot"],[]],"digraph { a -> b }"]}`
+		details = `{"t":"RawBlock","c":["html","<details><summary>Source</summary>"]}`
+		closing = `{"t":"RawBlock","c":["html","</details>"]}`
+	)
+	tests := []struct {
+		name    string
+		html    bool
+		blocks  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "not rendered",
+			blocks: pandocCodeBlock(`"dot"`, ""),
+			want:   code,
+		},
+		{
+			name:   "unknown language",
+			blocks: pandocCodeBlock(`"mermaid","render"`, ""),
+			want:   `{"t":"CodeBlock","c":[["",["mermaid","render"],[]],"digraph { a -> b }"]}`,
+		},
+		{
+			name:   "normal",
+			blocks: pandocCodeBlock(`"dot","render"`, ""),
+			want:   image + "," + code,
+		},
+		{
+			name:   "other attributes kept",
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","normal"],["startFrom","3"]`),
+			want:   image + `,{"t":"CodeBlock","c":[["",["dot"],[["startFrom","3"]]],"digraph { a -> b }"]}`,
+		},
+		{
+			name:   "code-collapsed",
+			html:   true,
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","code-collapsed"]`),
+			want:   image + "," + details + "," + code + "," + closing,
+		},
+		{
+			name:   "code-collapsed without html",
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","code-collapsed"]`),
+			want:   image + "," + code,
+		},
+		{
+			name:   "code-hidden",
+			html:   true,
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","code-hidden"]`),
+			want:   image,
+		},
+		{
+			name:   "image-below",
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","image-below"]`),
+			want:   code + "," + image,
+		},
+		{
+			name:   "side-by-side",
+			html:   true,
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","side-by-side"]`),
+			want:   `{"t":"RawBlock","c":["html","<table><tr><td>"]},` + code + `,{"t":"RawBlock","c":["html","</td><td>"]},` + image + `,{"t":"RawBlock","c":["html","</td></tr></table>"]}`,
+		},
+		{
+			name:   "side-by-side without html",
+			blocks: pandocCodeBlock(`"dot","render"`, `["mode","side-by-side"]`),
+			want:   code + "," + image,
+		},
+		{
+			name:   "nested",
+			blocks: `{"t":"BlockQuote","c":[` + pandocCodeBlock(`"dot","render"`, "") + `]}`,
+			want:   `{"t":"BlockQuote","c":[` + image + "," + code + `]}`,
+		},
+		{
+			name:   "figure",
+			blocks: pandocCodeBlock(`"dot","render"`, `["caption","Flow"],["id","flow"]`),
+			want:   `{"t":"Figure","c":[["fig-flow",[],[]],[null,[{"t":"Plain","c":[{"t":"Str","c":"Flow"}]}]],[` + strings.Replace(image, `"Para"`, `"Plain"`, 1) + `]]},` + code,
+		},
+		{
+			name:    "unknown mode",
+			blocks:  pandocCodeBlock(`"dot","render"`, `["mode","bogus"]`),
+			wantErr: true,
+		},
+		{
+			name:    "invalid dark option",
+			blocks:  pandocCodeBlock(`"dot","render"`, `["dark","sometimes"]`),
+			wantErr: true,
+		},
+		{
+			name:    "invalid format",
+			blocks:  pandocCodeBlock(`"dot","render"`, `["format","gif"]`),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRenderers(t)
+			f := pandocFilter{languages: []string{"dot"}, outputDir: t.TempDir(), html: tt.html}
+			doc := decodePandocJSON(t, `{"blocks":[`+tt.blocks+`]}`)
+			got, err := f.walk(doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("walk() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := decodePandocJSON(t, `{"blocks":[`+tt.want+`]}`)
+			if gotJSON, wantJSON := encodePandocJSON(t, got), encodePandocJSON(t, want); gotJSON != wantJSON {
+				t.Errorf("walk() =\n%s\nwant\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestPandocFilterFilenameTemplate(t *testing.T) {
+	fakeRenderers(t)
+	filenameTemplate, err := render.ParseFilenameTemplate("{{.Stem}}-{{.Index}}.{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := pandocFilter{languages: []string{"dot"}, outputDir: t.TempDir(), filenameTemplate: filenameTemplate}
+	block := pandocCodeBlock(`"dot","render"`, "")
+	doc := decodePandocJSON(t, `{"blocks":[`+block+","+block+`]}`)
+	got, err := f.walk(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotJSON := encodePandocJSON(t, got)
+	for _, v := range []string{"pandoc-1.svg", "pandoc-2.svg"} {
+		if !strings.Contains(gotJSON, `"`+v+`"`) {
+			t.Errorf("walk() =\n%s\nwant a link to %s", gotJSON, v)
+		}
+	}
+}
+
+func TestPandocFilterRenderOnlyChanged(t *testing.T) {
+	calls := fakeRenderers(t)
+	outputDir := t.TempDir()
+	walk := func(attributes string) {
+		t.Helper()
+		f := pandocFilter{languages: []string{"dot"}, outputDir: outputDir}
+		_, err := f.walk(decodePandocJSON(t, `{"blocks":[`+pandocCodeBlock(`"dot","render"`, attributes)+`]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk("")
+	walk("")
+	if got := calls(); got != 1 {
+		t.Errorf("rendered %d times, want the unchanged image rendered once", got)
+	}
+	// The filename is the same, but the image isn't
+	walk(`["width","100%"]`)
+	if got := calls(); got != 2 {
+		t.Errorf("rendered %d times, want the image rendered again with its new options", got)
+	}
+}
+
+func TestIsPandocHTMLFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   bool
+	}{
+		{"html5", true},
+		{"epub3", true},
+		{"gfm", true},
+		{"revealjs", true},
+		{"latex", false},
+		{"docx", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isPandocHTMLFormat(tt.format); got != tt.want {
+			t.Errorf("isPandocHTMLFormat(%q) = %v, want %v", tt.format, got, tt.want)
+		}
+	}
+}
+
+func decodePandocJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewBufferString(s))
+	decoder.UseNumber()
+	var v interface{}
+	err := decoder.Decode(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func encodePandocJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
@@ -1,143 +1,24 @@
 package main
 
 import (
-	"bytes"
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"text/template"
 
+	"github.com/benjaminheng/md-code-renderer/render"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
-// Match: ![render-db6d08bb022ed12c2cc74d86d7a4707d.svg](/optional/path/to/render-db6d08bb022ed12c2cc74d86d7a4707d.svg)
-// Capture group on the hash.
-var renderedImageRegexp = regexp.MustCompile(`!\[render-.{32}\..+\]\(.*render-(.{32})\..+\)`)
-
-var renderedHashRegexp = regexp.MustCompile(`<!-- hash:(.{8}) -->`)
-
-// Match: ![alt text](filename.ext)
-// Capture group on the filename.
-var markdownImageRegexp = regexp.MustCompile(`!\[.*\]\((.+)\)`)
-
-var (
-	defaultRenderMode    = "normal"
-	defaultRenderOptions = RenderOptions{Mode: defaultRenderMode}
-)
-
-type RenderOptions struct {
-	Mode     string `json:"mode"` // Modes: normal, code-collapsed, image-collapsed, code-hidden
-	Filename string `json:"filename"`
-}
-
-func (o *RenderOptions) Validate() error {
-	if o.Mode == "" {
-		o.Mode = defaultRenderMode
-	}
-	switch o.Mode {
-	case "normal", "code-collapsed", "image-collapsed", "code-hidden":
-	default:
-		return errors.New("unsupported mode")
-	}
-	return nil
-}
-
-// Chunk represents a segment of a file
-type Chunk struct {
-	Lines          []string // Lines the chunk contains
-	StartLineIndex int      // Index is relative to the input file
-	EndLineIndex   int      // Index is relative to the input file
-	CodeBlockIndex int      // Primarily for logging, to identify the problematic code block
-
-	IsRenderable           bool
-	Language               string
-	ImageRelativeLineIndex int    // Where the image is located in the chunk. Index is relative to the chunk's lines.
-	RenderedHash           string // If image has been rendered before, contains the hash of the code block previously used to render the image
-	HasHashComment         bool
-	CodeBlockContent       []string // The contents of the code block
-	RenderOptions          RenderOptions
-}
-
-func (r *Chunk) ShouldRender() bool {
-	if !r.IsRenderable {
-		return false
-	}
-
-	// Support both a full hash (32 characters) and a short hash (8 characters)
-	hash := r.HashContent()
-	shortHash := hash[:8]
-	if r.HashContent() != r.RenderedHash && shortHash != r.RenderedHash {
-		return true
-	}
-	return false
-}
-
-func (r *Chunk) HashContent() string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(r.CodeBlockContent, "\n"))))
-}
-
-func (r *Chunk) Render(outputDir string, linkPrefix string) (fileName string, err error) {
-	var content []byte
-	if r.RenderOptions.Filename != "" {
-		fileName = r.RenderOptions.Filename
-	} else {
-		fileName = "render-" + r.HashContent() + ".svg"
-	}
-
-	codeBlockContent := strings.Join(r.CodeBlockContent, "\n")
-	switch r.Language {
-	case "dot":
-		ext := extFromFilename(fileName, []string{"svg", "png"}, "svg")
-		content, err = runShellCommand("dot", []string{getDotFormatFlag(ext)}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render graphviz")
-		}
-	case "plantuml":
-		ext := extFromFilename(fileName, []string{"svg", "png"}, "svg")
-		content, err = runShellCommand("plantuml", []string{getPlantUMLFormatFlag(ext), "-pipe"}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render plantuml")
-		}
-	case "pikchr":
-		content, err = runShellCommand("pikchr", []string{"--svg-only", "-"}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render pikchr")
-		}
-	default:
-		return "", fmt.Errorf("unsupported type: %s", r.Language)
-	}
-
-	outputFilePath := path.Join(outputDir, fileName)
-	f, err := os.Create(outputFilePath)
-	if err != nil {
-		return "", errors.Wrap(err, "create output file")
-	}
-	defer f.Close()
-	f.Write(content)
-
-	// Update the chunk's lines
-	image := buildMarkdownImage(fileName, linkPrefix)
-	if r.HasHashComment {
-		hashComment := buildHashComment(r.HashContent()[:8])
-		image = image + " " + hashComment
-	}
-	r.Lines[r.ImageRelativeLineIndex] = image
-
-	return fileName, nil
-}
-
 func NewRenderCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "render",
+		Use:   "render [files...]",
 		Short: "Render code blocks in markdown files",
-		Long:  ``,
+		Long:  `Render code blocks in markdown files. Files are updated in place. If a file is "-", markdown is read from stdin and the result is written to stdout.`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return errors.New("no files specified as input")
@@ -146,17 +27,52 @@ func NewRenderCmd() *cobra.Command {
 		},
 		RunE: renderCmd,
 	}
-	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory to render code blocks to. If not specified, output will be rendered to the same directory as the input file.")
+	addRenderFlags(cmd)
+	return cmd
+}
+
+// addRenderFlags adds the flags that control rendering, which are shared by
+// the commands that render files.
+func addRenderFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory to render code blocks to. May be a template evaluated per input file, e.g. '{{.Dir}}/assets/{{.Stem}}'. If not specified, output will be rendered to the same directory as the input file.")
 	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "(required) Languages to render. Comma-separated. Supported languages: [dot, plantuml, pikchr].")
 	cmd.MarkFlagRequired("languages")
-	cmd.Flags().StringVar(&config.Render.LinkPrefix, "link-prefix", "", "Prefix to use when linking to rendered files")
-	return cmd
+	cmd.Flags().StringVar(&config.Render.LinkPrefix, "link-prefix", "", "Prefix to use when linking to rendered files, e.g. for absolute site roots. If not specified, links are relative to the markdown file.")
+	cmd.Flags().StringVar(&config.Render.FilenameTemplate, "filename-template", "", "Template for the filenames of rendered images, e.g. '{{.Stem}}-{{.Index}}-{{.ShortHash}}.{{.Ext}}'. Fields: Stem, Index, Hash, ShortHash, Ext, Language, Heading. If not specified, filenames are of the form render-{hash}.{format}.")
+	cmd.Flags().StringVar(&config.Render.DefaultFormat, "default-format", "svg", "Format to render code blocks to, if not specified by the code block. Supported formats: [svg, png, jpeg, pdf, webp].")
+	cmd.Flags().StringSliceVar(&config.Render.Formats, "formats", nil, "Formats to render code blocks to, if not specified by the code block. Comma-separated. The first format is linked to from the markdown file. Overrides --default-format.")
+	cmd.Flags().BoolVar(&config.Render.Dark, "dark", false, "Also render a dark variant of each image, shown when the reader prefers a dark color scheme. Images are then displayed with <picture> elements.")
+	cmd.Flags().StringVar(&config.Render.Inline, "inline", "", "Embed images in the markdown file instead of writing them to files, if not specified by the code block. Supported values: [svg, data-uri].")
+	cmd.Flags().BoolVar(&config.Render.SVG.Minify, "svg-minify", false, "Minify rendered SVGs, removing comments, redundant attributes and whitespace")
+	cmd.Flags().BoolVar(&config.Render.SVG.PrefixIDs, "svg-prefix-ids", false, "Prefix ids in rendered SVGs with the code block's hash, so that they don't collide when several SVGs are inlined on one page")
+	cmd.Flags().BoolVar(&config.Render.SVG.Sanitize, "svg-sanitize", false, "Keep only static elements and links with safe schemes in rendered SVGs, removing scripts, animations and event handlers")
+	cmd.Flags().StringVar(&config.Render.SVG.Width, "svg-width", "", "Explicit width of rendered SVGs, e.g. 100%. A viewBox is added if necessary, so that the SVG scales.")
+	cmd.Flags().StringVar(&config.Render.SVG.Height, "svg-height", "", "Explicit height of rendered SVGs. A viewBox is added if necessary, so that the SVG scales.")
+	cmd.Flags().Float64Var(&config.Render.Raster.Scale, "scale", 0, "Scale factor of rendered raster images (png, jpeg, webp), e.g. 2 for high-DPI screens")
+	cmd.Flags().IntVar(&config.Render.Raster.DPI, "dpi", 0, "Resolution of rendered raster images. If not specified, the renderer's default of 96 is used.")
+	cmd.Flags().IntVar(&config.Render.Raster.Padding, "padding", 0, "Padding around rendered raster images, in pixels")
+	cmd.Flags().StringVar(&config.Render.Raster.Background, "background", "", "Background color of rendered raster images, e.g. '#ffffff' or white. Dark variants keep a transparent background. If not specified, the background is transparent.")
+	cmd.Flags().BoolVar(&config.Render.Raster.Compress, "png-compress", false, "Recompress rendered PNGs losslessly at the best compression level, using a palette where possible")
+	cmd.Flags().StringVar(&config.Render.Templates, "templates", "", `Path to a JSON file defining custom modes, as templates by mode name, e.g. {"side-note": "{{.Code}}\n\n{{.Image}}"}. Fields: Image, Code, Caption, Hash, Language, Options.`)
+	cmd.Flags().StringVar(&config.Render.LinkStyle, "link-style", "markdown", "Syntax of the images that link to rendered files, for the static site generator or editor that displays them. Supported values: [markdown, hugo, mkdocs, docusaurus, obsidian].")
+	cmd.Flags().StringVar(&config.Render.CaptionStyle, "caption-style", "italic", "How captions of figures are written. Supported values: [italic, figure]. italic writes an italic line below the image, figure wraps the image in a <figure> element with a <figcaption>.")
 }
 
 func renderCmd(cmd *cobra.Command, args []string) error {
-	languages := strings.Split(config.Render.Languages, ",")
 	for _, v := range args {
-		err := processFile(v, languages, config.Render.OutputDir, config.Render.LinkPrefix)
+		if v != "-" {
+			config.Render.InputFiles = append(config.Render.InputFiles, v)
+		}
+	}
+	for _, v := range args {
+		if v == "-" {
+			err := processStdin(config.Render)
+			if err != nil {
+				return errors.Wrap(err, "process stdin")
+			}
+			continue
+		}
+		err := processFile(v, config.Render)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("process file %s", v))
 		}
@@ -164,7 +80,7 @@ func renderCmd(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func processFile(filePath string, types []string, outputDir string, linkPrefix string) error {
+func processFile(filePath string, cfg RenderConfig) error {
 	err := validateFileExists(filePath)
 	if err != nil {
 		return err
@@ -181,74 +97,20 @@ func processFile(filePath string, types []string, outputDir string, linkPrefix s
 		return errors.Wrap(err, fmt.Sprintf("read file %s", filePath))
 	}
 	inputFileContent := string(b)
-	lines := strings.Split(inputFileContent, "\n")
-
-	// Construct a lookup for O(1) access
-	typeLookup := make(map[string]bool)
-	for _, v := range types {
-		typeLookup[v] = true
-	}
 
-	// Split the file into chunks. A chunk can represent either a normal
-	// segment, or a renderable segment.
-	var chunks []*Chunk
-	var lastChunkIndex int
-	for idx, line := range lines {
-		// Skip ahead if these lines have been assigned a chunk already
-		if idx < lastChunkIndex {
-			continue
-		}
-		// Look for renderable code blocks
-		if strings.HasPrefix(line, "```") {
-			for k := range typeLookup {
-				if strings.HasPrefix(line, fmt.Sprintf("```%s render", k)) {
-					// Look at lines in and around the code
-					// block to determine the renderable chunk.
-					renderChunk, err := getRenderableChunk(lines, idx, k)
-					if err != nil {
-						return errors.Wrap(err, fmt.Sprintf("line %d: get renderable chunk", idx))
-					}
-					// Preceding lines not part of the renderable chunk are part of a
-					// normal chunk; construct one and add it to our list of chunks.
-					normalChunk := &Chunk{
-						StartLineIndex: lastChunkIndex,
-						EndLineIndex:   renderChunk.StartLineIndex - 1,
-					}
-					normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
-					chunks = append(chunks, normalChunk, renderChunk)
-					lastChunkIndex = renderChunk.EndLineIndex + 1
-					break
-				}
-			}
-		}
-	}
-	if lastChunkIndex < len(lines) {
-		// The rest of the file is a normal chunk
-		normalChunk := &Chunk{
-			StartLineIndex: lastChunkIndex,
-			EndLineIndex:   len(lines) - 1,
-		}
-		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
-		chunks = append(chunks, normalChunk)
+	opts, err := cfg.processOptions(filePath)
+	if err != nil {
+		return err
 	}
-
-	// Render the renderable chunks and join the chunks back into a file
-	var outputLines []string
-	for _, chunk := range chunks {
-		if chunk.ShouldRender() {
-			imageFileName, err := chunk.Render(outputDir, linkPrefix)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("line %d: render chunk", chunk.CodeBlockIndex+1))
-			}
-			fmt.Printf("[%s:%d] Rendered %s\n", filePath, chunk.CodeBlockIndex+1, imageFileName)
-		}
-		outputLines = append(outputLines, chunk.Lines...)
+	opts.Log = os.Stdout
+	outputContent, err := render.Process(inputFileContent, opts)
+	if err != nil {
+		return err
 	}
 
 	// Write to disk if file has changed
-	outputContent := strings.Join(outputLines, "\n")
 	if inputFileContent != outputContent {
-		writer, err := os.OpenFile(filePath, os.O_WRONLY, 0666)
+		writer, err := os.OpenFile(filePath, os.O_WRONLY|os.O_TRUNC, 0666)
 		if err != nil {
 			return errors.Wrap(err, "open file for writing")
 		}
@@ -259,101 +121,198 @@ func processFile(filePath string, types []string, outputDir string, linkPrefix s
 	return nil
 }
 
-func getRenderableChunk(lines []string, codeBlockIndex int, language string) (*Chunk, error) {
-	chunk := &Chunk{}
-	chunk.IsRenderable = true
-	chunk.Language = language
-	chunk.CodeBlockIndex = codeBlockIndex
-
-	fence := lines[codeBlockIndex]
-	renderOptionsJSON := strings.TrimPrefix(fence, fmt.Sprintf("```%s render", language))
-	if strings.HasPrefix(renderOptionsJSON, "{") && strings.HasSuffix(renderOptionsJSON, "}") {
-		var renderOptions RenderOptions
-		err := json.Unmarshal([]byte(renderOptionsJSON), &renderOptions)
-		if err != nil {
-			return nil, errors.Wrap(err, "unmarshal render options")
-		}
-		err = renderOptions.Validate()
-		if err != nil {
-			return nil, errors.Wrap(err, "validate render options")
-		}
-		chunk.RenderOptions = renderOptions
-	} else {
-		chunk.RenderOptions = defaultRenderOptions
+// processStdin reads markdown from stdin and writes the processed markdown to
+// stdout. Rendered images are still written to the output directory.
+// Progress is logged to stderr, to keep stdout clean for the output.
+func processStdin(cfg RenderConfig) error {
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "read stdin")
 	}
-
-	// Add a hash comment if a custom filename is set
-	if chunk.RenderOptions.Filename != "" {
-		chunk.HasHashComment = true
+	// Without a file, paths are relative to the working directory. Lists
+	// of figures only list the input's own images.
+	cfg.InputFiles = nil
+	opts, err := cfg.processOptions("stdin")
+	if err != nil {
+		return err
 	}
+	opts.Log = os.Stderr
+	outputContent, err := render.Process(string(b), opts)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.WriteString(outputContent)
+	return err
+}
 
-	var err error
-	renderTemplateManager := RenderTemplateManager{}
-	switch chunk.RenderOptions.Mode {
-	case "normal":
-		err = renderTemplateManager.Normal(lines, codeBlockIndex, chunk)
-	case "code-collapsed":
-		err = renderTemplateManager.CodeCollapsed(lines, codeBlockIndex, chunk)
-	case "image-collapsed":
-		err = renderTemplateManager.ImageCollapsed(lines, codeBlockIndex, chunk)
-	case "code-hidden":
-		err = renderTemplateManager.CodeHidden(lines, codeBlockIndex, chunk)
-	default:
-		return nil, errors.New("unsupported mode")
+// processOptions returns the options for rendering the markdown file at
+// filePath, with images written to the file's output directory.
+func (c RenderConfig) processOptions(filePath string) (render.Options, error) {
+	outputDir, err := resolveOutputDir(filePath, c.OutputDir)
+	if err != nil {
+		return render.Options{}, err
 	}
+	linkPrefix, err := resolveLinkPrefix(filepath.Dir(filePath), outputDir, c.LinkPrefix)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse render template")
+		return render.Options{}, err
 	}
-
-	return chunk, nil
+	modeTemplates, err := c.modeTemplates()
+	if err != nil {
+		return render.Options{}, err
+	}
+	linkStyle, err := render.ParseLinkStyle(c.LinkStyle)
+	if err != nil {
+		return render.Options{}, err
+	}
+	opts := render.Options{
+		Name:             filePath,
+		Languages:        c.languages(),
+		FilenameTemplate: c.FilenameTemplate,
+		DefaultFormats:   c.defaultFormats(),
+		ExtraFormats:     c.Formats,
+		Dark:             c.Dark,
+		Inline:           c.Inline,
+		SVG:              c.SVG,
+		Raster:           c.Raster,
+		CaptionStyle:     c.CaptionStyle,
+		ModeTemplates:    modeTemplates,
+		LinkStyle:        linkStyle,
+		RenderChunk: func(chunk *render.Chunk) (string, error) {
+			return chunk.Render(outputDir, linkPrefix)
+		},
+	}
+	if len(c.InputFiles) > 0 {
+		opts.InputSetFigures = func() ([]render.FigureListEntry, error) {
+			return c.inputSetFigures(filePath)
+		}
+	}
+	return opts, nil
 }
 
-func runShellCommand(command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
-	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = stdin
-	stdout := &bytes.Buffer{}
-	cmd.Stdout = stdout
-	err = cmd.Run()
-	return stdout.Bytes(), err
+// modeTemplates reads and parses the templates of custom modes, if any.
+func (c RenderConfig) modeTemplates() (map[string]*template.Template, error) {
+	if c.Templates == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(c.Templates)
+	if err != nil {
+		return nil, errors.Wrap(err, "read templates")
+	}
+	var texts map[string]render.ModeTemplateText
+	err = json.Unmarshal(b, &texts)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unmarshal templates %s", c.Templates))
+	}
+	return render.ParseModeTemplates(texts)
 }
 
-func buildMarkdownImage(outputFilename, linkPrefix string) string {
-	return fmt.Sprintf("![%s](%s)", outputFilename, linkPrefix+outputFilename)
+// inputSetFigures returns the entries of the images rendered from the input
+// files, for lists of figures in the markdown file at filePath. Links are
+// relative to filePath.
+func (c RenderConfig) inputSetFigures(filePath string) ([]render.FigureListEntry, error) {
+	markdownDir := filepath.Dir(filePath)
+	var entries []render.FigureListEntry
+	for _, v := range c.InputFiles {
+		b, err := os.ReadFile(v)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("read file %s", v))
+		}
+		opts, err := c.processOptions(v)
+		if err != nil {
+			return nil, err
+		}
+		chunks, err := render.ParseChunks(string(b), opts)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("parse file %s", v))
+		}
+		outputDir, err := resolveOutputDir(v, c.OutputDir)
+		if err != nil {
+			return nil, err
+		}
+		linkPrefix, err := resolveLinkPrefix(markdownDir, outputDir, c.LinkPrefix)
+		if err != nil {
+			return nil, err
+		}
+		var document string
+		if filepath.Clean(v) != filepath.Clean(filePath) {
+			document, err = filepath.Rel(markdownDir, v)
+			if err != nil {
+				return nil, errors.Wrap(err, "compute relative link")
+			}
+			document = filepath.ToSlash(document)
+		}
+		for _, chunk := range chunks {
+			if !chunk.IsRenderable {
+				continue
+			}
+			var image string
+			if chunk.Inline() == "" {
+				image = linkPrefix + chunk.FileName()
+			}
+			entry := chunk.FigureListEntry(image)
+			entry.Document = document
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
 }
 
-func buildHashComment(hash string) string {
-	return fmt.Sprintf("<!-- hash:%s -->", hash)
+// outputDirTemplateData is the data available to --output-dir templates.
+type outputDirTemplateData struct {
+	Dir  string // Directory containing the markdown file
+	Name string // Filename of the markdown file
+	Stem string // Filename of the markdown file, without its extension
 }
 
-func extFromFilename(filename string, acceptedExtensions []string, defaultExtension string) string {
-	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
-	for _, v := range acceptedExtensions {
-		if ext == v {
-			return ext
-		}
+// resolveOutputDir evaluates the output directory for a markdown file. The
+// output directory may be a template, e.g. "{{.Dir}}/assets/{{.Stem}}". If
+// it is empty, the directory containing the markdown file is used.
+func resolveOutputDir(markdownFilePath string, outputDir string) (string, error) {
+	if outputDir == "" {
+		return filepath.Dir(markdownFilePath), nil
 	}
-	return defaultExtension
-}
-
-func getDotFormatFlag(fileExtension string) string {
-	switch fileExtension {
-	case "png":
-		return "-Tpng"
-	case "svg":
-		return "-Tsvg"
-	default:
-		return "-Tsvg"
+	if !strings.Contains(outputDir, "{{") {
+		return outputDir, nil
+	}
+	tmpl, err := template.New("output-dir").Option("missingkey=error").Parse(outputDir)
+	if err != nil {
+		return "", errors.Wrap(err, "parse output directory template")
+	}
+	name := filepath.Base(markdownFilePath)
+	data := outputDirTemplateData{
+		Dir:  filepath.Dir(markdownFilePath),
+		Name: name,
+		Stem: strings.TrimSuffix(name, filepath.Ext(name)),
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		return "", errors.Wrap(err, "execute output directory template")
 	}
+	return filepath.Clean(b.String()), nil
 }
 
-func getPlantUMLFormatFlag(fileExtension string) string {
-	switch fileExtension {
-	case "png":
-		return "-tpng"
-	case "svg":
-		return "-tsvg"
-	default:
-		return "-tsvg"
+// resolveLinkPrefix returns the prefix to use when linking to images in
+// outputDir from a markdown file in markdownDir. An explicit linkPrefix takes
+// precedence.
+func resolveLinkPrefix(markdownDir string, outputDir string, linkPrefix string) (string, error) {
+	if linkPrefix != "" {
+		return linkPrefix, nil
+	}
+	absMarkdownDir, err := filepath.Abs(markdownDir)
+	if err != nil {
+		return "", errors.Wrap(err, "resolve markdown directory")
+	}
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", errors.Wrap(err, "resolve output directory")
+	}
+	rel, err := filepath.Rel(absMarkdownDir, absOutputDir)
+	if err != nil {
+		return "", errors.Wrap(err, "compute relative link")
+	}
+	if rel == "." {
+		return "", nil
 	}
+	return filepath.ToSlash(rel) + "/", nil
 }
@@ -0,0 +1,213 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// languageNames are the display names of each language, used in default alt
+// text.
+var languageNames = map[string]string{
+	"dot":      "Graphviz",
+	"plantuml": "PlantUML",
+	"pikchr":   "Pikchr",
+}
+
+var (
+	// Match: title Some title
+	// Capture group on the title.
+	plantumlTitleRegexp = regexp.MustCompile(`(?mi)^\s*title\s+(.+?)\s*$`)
+
+	// Match: # caption: Some caption, // caption: Some caption
+	// Capture group on the caption.
+	pikchrCaptionRegexp = regexp.MustCompile(`(?mi)^\s*(?:#|//)\s*caption:\s*(.+?)\s*$`)
+
+	// Match: <svg ...>
+	svgStartTagRegexp = regexp.MustCompile(`(?s)<svg\b[^>]*>`)
+
+	htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+	// Escape sequences in Graphviz labels that break lines
+	dotLineBreakReplacer = strings.NewReplacer(`\n`, " ", `\l`, " ", `\r`, " ", `\"`, `"`, `\\`, `\`)
+)
+
+// AltText returns the alt text of the chunk's image. If the chunk has no alt
+// option, the diagram's own title is used: the graph label in Graphviz, the
+// title in PlantUML, or a "# caption:" comment in Pikchr. Otherwise the alt
+// text names the kind of diagram.
+func (r *Chunk) AltText() string {
+	if r.RenderOptions.Alt != "" {
+		return r.RenderOptions.Alt
+	}
+	if title := r.diagramTitle(); title != "" {
+		return title
+	}
+	name, ok := languageNames[r.Language]
+	if !ok {
+		name = r.Language
+	}
+	return name + " diagram"
+}
+
+// Title returns the title of the chunk's image, which is usually shown as a
+// tooltip, or an empty string if it has none.
+func (r *Chunk) Title() string {
+	return r.RenderOptions.Title
+}
+
+// diagramTitle extracts the title from the diagram's source, or returns an
+// empty string if it has none.
+func (r *Chunk) diagramTitle() string {
+	content := strings.Join(r.CodeBlockContent, "\n")
+	var title string
+	switch r.Language {
+	case "dot":
+		title = dotGraphLabel(content)
+	case "plantuml":
+		if matches := plantumlTitleRegexp.FindStringSubmatch(content); len(matches) == 2 {
+			title = strings.ReplaceAll(matches[1], `\n`, " ")
+		}
+	case "pikchr":
+		if matches := pikchrCaptionRegexp.FindStringSubmatch(content); len(matches) == 2 {
+			title = matches[1]
+		}
+	}
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// dotGraphLabel returns the label of the top-level graph in a Graphviz
+// diagram, set either as "label=..." in the graph's body, or in a "graph [...]"
+// attribute statement. Labels of subgraphs, nodes and edges are ignored.
+func dotGraphLabel(content string) string {
+	tokens := dotTokens(content)
+	braceDepth, bracketDepth := 0, 0
+	inGraphAttrs := false
+	for i, v := range tokens {
+		switch v {
+		case "{":
+			braceDepth++
+			continue
+		case "}":
+			braceDepth--
+			continue
+		case "[":
+			bracketDepth++
+			inGraphAttrs = braceDepth == 1 && bracketDepth == 1 && i > 0 && strings.EqualFold(tokens[i-1], "graph")
+			continue
+		case "]":
+			bracketDepth--
+			inGraphAttrs = false
+			continue
+		}
+		if braceDepth != 1 || (bracketDepth != 0 && !inGraphAttrs) {
+			continue
+		}
+		if v == "label" && i+2 < len(tokens) && tokens[i+1] == "=" {
+			return dotLabelText(tokens[i+2])
+		}
+	}
+	return ""
+}
+
+// dotLabelText returns the text of a label token, which may be quoted or an
+// HTML-like label.
+func dotLabelText(token string) string {
+	switch {
+	case strings.HasPrefix(token, `"`):
+		return dotLineBreakReplacer.Replace(strings.TrimSuffix(strings.TrimPrefix(token, `"`), `"`))
+	case strings.HasPrefix(token, "<"):
+		return html.UnescapeString(htmlTagRegexp.ReplaceAllString(token[1:len(token)-1], " "))
+	}
+	return token
+}
+
+// dotTokens splits a Graphviz diagram into tokens: identifiers, quoted
+// strings, HTML-like strings and punctuation. Comments are skipped.
+func dotTokens(content string) []string {
+	var tokens []string
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+		case c == '#' || (c == '/' && i+1 < len(runes) && runes[i+1] == '/'):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == '"':
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				return tokens
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case c == '<':
+			start, depth := i, 0
+			for ; i < len(runes); i++ {
+				if runes[i] == '<' {
+					depth++
+				} else if runes[i] == '>' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if i >= len(runes) {
+				return tokens
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case strings.ContainsRune("{}[]=;,:", c):
+			tokens = append(tokens, string(c))
+		default:
+			start := i
+			for i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !strings.ContainsRune("{}[]=;,:\"<#", runes[i+1]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		}
+	}
+	return tokens
+}
+
+// addSVGTitle adds <title> and <desc> elements to the SVG's root element, so
+// that screen readers can describe it. The title is used for <title>, and
+// alt for <desc>. If the title is empty, alt is used for <title> instead.
+func addSVGTitle(svg []byte, alt string, title string) []byte {
+	loc := svgStartTagRegexp.FindIndex(svg)
+	if loc == nil || bytes.HasSuffix(svg[loc[0]:loc[1]], []byte("/>")) {
+		return svg
+	}
+	var elements string
+	switch {
+	case title == "" || title == alt:
+		elements = "<title>" + escapeXMLText(alt) + "</title>"
+	case alt == "":
+		elements = "<title>" + escapeXMLText(title) + "</title>"
+	default:
+		elements = "<title>" + escapeXMLText(title) + "</title><desc>" + escapeXMLText(alt) + "</desc>"
+	}
+	startTag := svg[loc[0]:loc[1]]
+	if !bytes.Contains(startTag, []byte(" role=")) {
+		startTag = append(append(startTag[:len(startTag)-1:len(startTag)-1], ` role="img"`...), '>')
+	}
+	var b bytes.Buffer
+	b.Write(svg[:loc[0]])
+	b.Write(startTag)
+	b.WriteString(elements)
+	b.Write(svg[loc[1]:])
+	return b.Bytes()
+}
@@ -0,0 +1,68 @@
+package render
+
+import "testing"
+
+func TestAltText(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		content  string
+		alt      string
+		want     string
+	}{
+		{"dot graph label", "dot", `digraph { label="Auth flow"; a -> b }`, "", "Auth flow"},
+		{"dot unquoted label", "dot", `digraph G { label=Flow; a -> b }`, "", "Flow"},
+		{"dot graph attribute", "dot", `digraph { graph [rankdir=LR, label="Auth flow"]; a -> b }`, "", "Auth flow"},
+		{"dot line breaks", "dot", `digraph { label="Auth\nflow\l"; }`, "", "Auth flow"},
+		{"dot escaped quotes", "dot", `digraph { label="The \"flow\""; }`, "", `The "flow"`},
+		{"dot html label", "dot", `digraph { label=<<b>Auth</b> &amp; flow>; }`, "", "Auth & flow"},
+		{"dot node label ignored", "dot", `digraph { a [label="Node"]; a -> b [label="Edge"] }`, "", "Graphviz diagram"},
+		{"dot subgraph label ignored", "dot", `digraph { subgraph cluster_a { label="Cluster" } }`, "", "Graphviz diagram"},
+		{"dot node attribute label ignored", "dot", `digraph { node [label="Node"]; a }`, "", "Graphviz diagram"},
+		{"dot label after subgraph", "dot", `digraph { subgraph { label="Inner" } label="Outer" }`, "", "Outer"},
+		{"dot commented label ignored", "dot", "digraph {\n// label=\"Comment\"\n# label=\"Hash\"\n/* label=\"Block\" */\na }", "", "Graphviz diagram"},
+		{"dot label in string ignored", "dot", `digraph { a [tooltip="label=x"] }`, "", "Graphviz diagram"},
+		{"plantuml title", "plantuml", "@startuml\ntitle Auth flow\nA -> B\n@enduml", "", "Auth flow"},
+		{"plantuml title case", "plantuml", "@startuml\n  TITLE   Auth   flow  \n@enduml", "", "Auth flow"},
+		{"plantuml title line break", "plantuml", "@startuml\ntitle Auth\\nflow\n@enduml", "", "Auth flow"},
+		{"plantuml no title", "plantuml", "@startuml\nA -> B : title\n@enduml", "", "PlantUML diagram"},
+		{"pikchr hash caption", "pikchr", "# caption: Auth flow\nbox \"A\"", "", "Auth flow"},
+		{"pikchr slash caption", "pikchr", "box \"A\"\n  // Caption:  Auth flow", "", "Auth flow"},
+		{"pikchr no caption", "pikchr", "# A box\nbox \"A\"", "", "Pikchr diagram"},
+		{"alt option", "dot", `digraph { label="Auth flow" }`, "Custom", "Custom"},
+		{"unknown language", "mermaid", "graph TD", "", "mermaid diagram"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := NewChunk(tt.language, tt.content, RenderOptions{Alt: tt.alt})
+			if got := chunk.AltText(); got != tt.want {
+				t.Errorf("AltText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddSVGTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		svg   string
+		alt   string
+		title string
+		want  string
+	}{
+		{"alt only", `<svg width="1"><g/></svg>`, "Alt", "", `<svg width="1" role="img"><title>Alt</title><g/></svg>`},
+		{"title equals alt", `<svg><g/></svg>`, "Alt", "Alt", `<svg role="img"><title>Alt</title><g/></svg>`},
+		{"title and alt", `<svg><g/></svg>`, "Alt", "Title", `<svg role="img"><title>Title</title><desc>Alt</desc><g/></svg>`},
+		{"escaped", `<svg><g/></svg>`, "a < b & c", "", `<svg role="img"><title>a &lt; b &amp; c</title><g/></svg>`},
+		{"existing role", `<svg role="graphics-document"><g/></svg>`, "Alt", "", `<svg role="graphics-document"><title>Alt</title><g/></svg>`},
+		{"self-closing", `<svg/>`, "Alt", "", `<svg/>`},
+		{"not an svg", `<p>x</p>`, "Alt", "", `<p>x</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(addSVGTitle([]byte(tt.svg), tt.alt, tt.title)); got != tt.want {
+				t.Errorf("addSVGTitle() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ModeTemplateData is the data available to the templates of modes.
+type ModeTemplateData struct {
+	Image    string        // The image, e.g. a markdown image or a <picture> element
+	Code     string        // The fenced code block, including its fences. In the "replace" mode, the reference to its sidecar file instead.
+	Caption  string        // Caption of the figure, e.g. "Figure 1: Caption", or an empty string if the image isn't a figure
+	Hash     string        // Short hash of the code block's content
+	Language string        // Language of the code block
+	Options  RenderOptions // Render options of the code block
+}
+
+// ModeTemplateText is the text of a custom mode's template. In JSON, it is
+// either a string, or a list of lines.
+type ModeTemplateText string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *ModeTemplateText) UnmarshalJSON(b []byte) error {
+	var lines []string
+	if err := json.Unmarshal(b, &lines); err == nil {
+		*t = ModeTemplateText(strings.Join(lines, "\n"))
+		return nil
+	}
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New("template must be a string or a list of lines")
+	}
+	*t = ModeTemplateText(s)
+	return nil
+}
+
+// ParseModeTemplates parses and validates the templates of custom modes.
+func ParseModeTemplates(texts map[string]ModeTemplateText) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for name, text := range texts {
+		if _, ok := builtinModeTemplates[name]; ok {
+			return nil, errors.Errorf("mode %s is built in and can't be redefined", name)
+		}
+		if !modeNameRegexp.MatchString(name) {
+			return nil, errors.Errorf("invalid mode name %q, must only contain lowercase letters, digits and -", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("parse template for mode %s", name))
+		}
+		sample := ModeTemplateData{
+			Image:    "![Graphviz diagram](render-00000000000000000000000000000000.svg)",
+			Code:     "```dot render\ndigraph {}\n```",
+			Caption:  "Figure 1: Caption",
+			Hash:     strings.Repeat("0", 8),
+			Language: "dot",
+			Options:  defaultRenderOptions,
+		}
+		output, err := executeModeTemplate(tmpl, sample)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("template for mode %s", name))
+		}
+		// Without the code block, the source would be lost from the
+		// document. Without the image, the code block would be
+		// rendered again on every run.
+		if strings.Count(output, sample.Code) != 1 {
+			return nil, errors.Errorf("template for mode %s must write {{.Code}} exactly once", name)
+		}
+		if !strings.Contains(output, sample.Image) {
+			return nil, errors.Errorf("template for mode %s must write {{.Image}}", name)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+func executeModeTemplate(tmpl *template.Template, data ModeTemplateData) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		return "", errors.Wrap(err, "execute template")
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
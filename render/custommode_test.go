@@ -0,0 +1,123 @@
+package render
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseModeTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		texts   string
+		wantErr bool
+	}{
+		{name: "string", texts: `{"side-note": "{{.Code}}\n\n{{.Image}}"}`},
+		{name: "lines", texts: `{"two-column": ["<table><tr><td>", "", "{{.Code}}", "", "</td><td>{{.Image}}</td></tr></table>"]}`},
+		{name: "fields", texts: `{"full": "{{.Image}} {{.Caption}} {{.Hash}} {{.Language}} {{.Options.Mode}}\n{{.Code}}"}`},
+		{name: "built in mode", texts: `{"normal": "{{.Image}}"}`, wantErr: true},
+		{name: "side-by-side is built in", texts: `{"side-by-side": "{{.Code}}{{.Image}}"}`, wantErr: true},
+		{name: "invalid name", texts: `{"Side Note": "{{.Image}}"}`, wantErr: true},
+		{name: "invalid template", texts: `{"side-note": "{{.Image"}`, wantErr: true},
+		{name: "unknown field", texts: `{"side-note": "{{.Picture}}"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var texts map[string]ModeTemplateText
+			err := json.Unmarshal([]byte(tt.texts), &texts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ParseModeTemplates(texts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseModeTemplates() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessCustomMode(t *testing.T) {
+	const (
+		imageB = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		imageC = "![Graphviz diagram](render-04579f4a9b61bbc54c6661af818353c5.svg)"
+		codeB  = "```dot render{\"mode\": \"side-note\"}\ndigraph { a -> b }\n```"
+		codeC  = "```dot render{\"mode\": \"side-note\"}\ndigraph { a -> c }\n```"
+	)
+	tests := []struct {
+		name        string
+		doc         string
+		want        string
+		wantRenders int
+		wantErr     bool
+	}{
+		{
+			name:        "new code block",
+			doc:         "# Doc\n\n" + codeB + "\n\nText",
+			want:        "# Doc\n\n<!-- md-code-renderer:begin mode=side-note hash=82682d8f -->\n" + codeB + "\n\n> " + imageB + "\n<!-- md-code-renderer:end -->\n\nText",
+			wantRenders: 1,
+		},
+		{
+			name: "rendered before",
+			doc:  "<!-- md-code-renderer:begin mode=side-note hash=82682d8f -->\n" + codeB + "\n\n> " + imageB + "\n<!-- md-code-renderer:end -->",
+			want: "<!-- md-code-renderer:begin mode=side-note hash=82682d8f -->\n" + codeB + "\n\n> " + imageB + "\n<!-- md-code-renderer:end -->",
+		},
+		{
+			name:        "code changed",
+			doc:         "<!-- md-code-renderer:begin mode=side-note hash=82682d8f -->\n" + codeC + "\n\n> " + imageB + "\n<!-- md-code-renderer:end -->",
+			want:        "<!-- md-code-renderer:begin mode=side-note hash=04579f4a -->\n" + codeC + "\n\n> " + imageC + "\n<!-- md-code-renderer:end -->",
+			wantRenders: 1,
+		},
+		{
+			name:        "edited output replaced",
+			doc:         "<!-- md-code-renderer:begin mode=side-note hash=82682d8f -->\nEdited\n" + codeC + "\n<!-- md-code-renderer:end -->",
+			want:        "<!-- md-code-renderer:begin mode=side-note hash=04579f4a -->\n" + codeC + "\n\n> " + imageC + "\n<!-- md-code-renderer:end -->",
+			wantRenders: 1,
+		},
+		{
+			name:    "unknown mode",
+			doc:     "```dot render{\"mode\": \"two-column\"}\ndigraph { a -> b }\n```",
+			wantErr: true,
+		},
+	}
+	templates, err := ParseModeTemplates(map[string]ModeTemplateText{
+		"side-note": "{{.Code}}\n\n> {{.Image}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renders int
+			opts := Options{
+				Languages:     []string{"dot"},
+				ModeTemplates: templates,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			renders = 0
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got || renders != 0 {
+				t.Errorf("Process() again =\n%s\nwith %d renders, want it unchanged", again, renders)
+			}
+		})
+	}
+}
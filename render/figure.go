@@ -0,0 +1,228 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCaptionStyle = "italic"
+
+	// figureLabel precedes the number of each figure, in captions and
+	// cross-references.
+	figureLabel = "Figure"
+)
+
+var (
+	// Match: <figure id="fig-id">...<figcaption>Figure 1: Caption</figcaption></figure>
+	// Capture group on the image.
+	figureCaptionRegexp = regexp.MustCompile(`^<figure(?: id="[^"]*")?>(.*?)<figcaption>.*</figcaption></figure>$`)
+
+	// Match: <a id="fig-id"></a>![](...)<br>*Figure 1: Caption*
+	// Capture group on the image.
+	italicCaptionRegexp = regexp.MustCompile(`^(?:<a id="[^"]*"></a>)?(.*?)<br>\*.*\*$`)
+
+	// Match: <img alt="Alt text" src="image.svg" title="Title">
+	// Capture groups on the alt text, the link and the title.
+	htmlImageRegexp = regexp.MustCompile(`^<img alt="(.*?)" src="(.*?)"(?: title="(.*?)")?>$`)
+
+	// Match: @fig:auth-flow
+	// Capture groups on the preceding character and the id.
+	figureReferenceRegexp = regexp.MustCompile(`(^|[^\w\[])@fig:([A-Za-z0-9_-]+)`)
+
+	// Match: [Figure 1](#fig-auth-flow)
+	// Capture group on the id.
+	figureLinkRegexp = regexp.MustCompile(`\[` + figureLabel + ` \d+\]\(#fig-([A-Za-z0-9_-]+)\)`)
+
+	figureIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	trailingHashCommentRegexp = regexp.MustCompile(` ?<!-- hash:.{8} -->$`)
+)
+
+// ValidateCaptionStyle returns an error if style is not a supported caption
+// style.
+func ValidateCaptionStyle(style string) error {
+	switch style {
+	case "", "italic", "figure":
+		return nil
+	}
+	return fmt.Errorf("unsupported caption style %q, must be one of: italic, figure", style)
+}
+
+func validateFigureID(id string) error {
+	if id != "" && !figureIDRegexp.MatchString(id) {
+		return fmt.Errorf("invalid id %q, must only contain letters, digits, - and _", id)
+	}
+	return nil
+}
+
+// IsFigure returns whether the chunk's image is a numbered figure, which it
+// is if it has a caption or an id.
+func (r *Chunk) IsFigure() bool {
+	return r.RenderOptions.Caption != "" || r.RenderOptions.ID != ""
+}
+
+// FigureAnchor returns the id of the figure's anchor, which cross-references
+// link to, or an empty string if it has none.
+func (r *Chunk) FigureAnchor() string {
+	if r.RenderOptions.ID == "" {
+		return ""
+	}
+	return "fig-" + r.RenderOptions.ID
+}
+
+// FigureCaption returns the figure's caption, prefixed with its number, e.g.
+// "Figure 1: Caption".
+func (r *Chunk) FigureCaption() string {
+	caption := fmt.Sprintf("%s %d", figureLabel, r.FigureNumber)
+	if r.RenderOptions.Caption != "" {
+		caption += ": " + r.RenderOptions.Caption
+	}
+	return caption
+}
+
+func (r *Chunk) captionStyle() string {
+	if r.CaptionStyle == "" {
+		return defaultCaptionStyle
+	}
+	return r.CaptionStyle
+}
+
+// captioned returns the image with the chunk's caption, if it is a figure. In
+// the figure style, the image is wrapped in a <figure> element, so markdown
+// images are converted to <img> elements. In the italic style, the caption
+// follows the image on the same line.
+func (r *Chunk) captioned(image string) string {
+	if !r.IsFigure() {
+		return image
+	}
+	var anchor string
+	if r.captionStyle() == "figure" {
+		if r.FigureAnchor() != "" {
+			anchor = fmt.Sprintf(` id="%s"`, r.FigureAnchor())
+		}
+		return fmt.Sprintf("<figure%s>%s<figcaption>%s</figcaption></figure>", anchor, toHTMLImage(image), html.EscapeString(r.FigureCaption()))
+	}
+	if r.FigureAnchor() != "" {
+		anchor = fmt.Sprintf(`<a id="%s"></a>`, r.FigureAnchor())
+	}
+	return fmt.Sprintf("%s%s<br>*%s*", anchor, image, r.FigureCaption())
+}
+
+// renderedHashSuffix returns the hash comment at the end of the line,
+// including the preceding space, or an empty string if there is none.
+func renderedHashSuffix(line string) string {
+	return trailingHashCommentRegexp.FindString(line)
+}
+
+// stripCaption returns the image without its caption.
+func stripCaption(image string) string {
+	if matches := figureCaptionRegexp.FindStringSubmatch(image); len(matches) == 2 {
+		return matches[1]
+	}
+	if matches := italicCaptionRegexp.FindStringSubmatch(image); len(matches) == 2 {
+		return matches[1]
+	}
+	return image
+}
+
+// toHTMLImage converts a markdown image to an <img> element. Other images are
+// returned as-is.
+func toHTMLImage(image string) string {
+	matches := markdownImageRegexp.FindStringSubmatch(image)
+	if len(matches) != 4 || matches[0] != image {
+		return image
+	}
+	alt, link, title := unescapeMarkdown(matches[1]), matches[2], unescapeMarkdown(matches[3])
+	return buildHTMLImage(alt, title, link)
+}
+
+func buildHTMLImage(alt string, title string, link string) string {
+	var titleAttr string
+	if title != "" {
+		titleAttr = fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+	}
+	return fmt.Sprintf(`<img alt="%s" src="%s"%s>`, html.EscapeString(alt), html.EscapeString(link), titleAttr)
+}
+
+// numberFigures numbers the figures among the chunks, in document order.
+// Returns an error if two figures have the same id.
+func numberFigures(chunks []*Chunk) error {
+	ids := make(map[string]bool)
+	var count int
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable || !chunk.IsFigure() {
+			continue
+		}
+		count++
+		chunk.FigureNumber = count
+		if id := chunk.RenderOptions.ID; id != "" {
+			if ids[id] {
+				return &ParseError{LineIndex: chunk.CodeBlockIndex, Err: errors.Errorf("duplicate figure id %q", id)}
+			}
+			ids[id] = true
+		}
+	}
+	return nil
+}
+
+// figureNumbers returns the numbers of the figures with ids, by id.
+func figureNumbers(chunks []*Chunk) map[string]int {
+	numbers := make(map[string]int)
+	for _, chunk := range chunks {
+		if chunk.IsRenderable && chunk.RenderOptions.ID != "" {
+			numbers[chunk.RenderOptions.ID] = chunk.FigureNumber
+		}
+	}
+	return numbers
+}
+
+// updateFigureReferences rewrites references to figures in the lines of
+// normal chunks, outside of code. "@fig:id" is rewritten to a link such as
+// "[Figure 1](#fig-id)", and the numbers of existing links are updated.
+// References to unknown figures are returned.
+func updateFigureReferences(chunks []*Chunk, numbers map[string]int) (unknown []string) {
+	var inCodeBlock bool
+	for _, chunk := range chunks {
+		if chunk.IsRenderable {
+			continue
+		}
+		for i, line := range chunk.Lines {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				inCodeBlock = !inCodeBlock
+				continue
+			}
+			if inCodeBlock {
+				continue
+			}
+			// Code spans are the odd segments between backticks
+			segments := strings.Split(line, "`")
+			for j := 0; j < len(segments); j += 2 {
+				segments[j] = figureReferenceRegexp.ReplaceAllStringFunc(segments[j], func(match string) string {
+					submatches := figureReferenceRegexp.FindStringSubmatch(match)
+					number, ok := numbers[submatches[2]]
+					if !ok {
+						unknown = append(unknown, submatches[2])
+						return match
+					}
+					return fmt.Sprintf("%s[%s %d](#fig-%s)", submatches[1], figureLabel, number, submatches[2])
+				})
+				segments[j] = figureLinkRegexp.ReplaceAllStringFunc(segments[j], func(match string) string {
+					id := figureLinkRegexp.FindStringSubmatch(match)[1]
+					number, ok := numbers[id]
+					if !ok {
+						unknown = append(unknown, id)
+						return match
+					}
+					return fmt.Sprintf("[%s %d](#fig-%s)", figureLabel, number, id)
+				})
+			}
+			chunk.Lines[i] = strings.Join(segments, "`")
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,102 @@
+package render
+
+import (
+	"testing"
+)
+
+func TestProcessFigures(t *testing.T) {
+	const (
+		imageB = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		imageC = "![Graphviz diagram](render-04579f4a9b61bbc54c6661af818353c5.svg)"
+	)
+	tests := []struct {
+		name         string
+		doc          string
+		captionStyle string
+		want         string
+		wantRenders  int
+		wantErr      bool
+	}{
+		{
+			name:        "caption",
+			doc:         "```dot render{\"caption\": \"Flow\"}\ndigraph { a -> b }\n```",
+			want:        region("normal", "82682d8f", imageB+"<br>*Figure 1: Flow*\n\n```dot render{\"caption\": \"Flow\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:         "figure style",
+			doc:          "```dot render{\"caption\": \"A & B\", \"id\": \"ab\"}\ndigraph { a -> b }\n```",
+			captionStyle: "figure",
+			want:         region("normal", "82682d8f", `<figure id="fig-ab"><img alt="Graphviz diagram" src="render-82682d8f229ac783001529cc84b0b85b.svg"><figcaption>Figure 1: A &amp; B</figcaption></figure>`+"\n\n```dot render{\"caption\": \"A & B\", \"id\": \"ab\"}\ndigraph { a -> b }\n```"),
+			wantRenders:  1,
+		},
+		{
+			name:        "references",
+			doc:         "See @fig:ab, not `@fig:ab` or email@fig:ab.\n\n```\n@fig:ab\n```\n\n```dot render{\"id\": \"ab\"}\ndigraph { a -> b }\n```",
+			want:        "See [Figure 1](#fig-ab), not `@fig:ab` or email@fig:ab.\n\n```\n@fig:ab\n```\n\n" + region("normal", "82682d8f", "<a id=\"fig-ab\"></a>"+imageB+"<br>*Figure 1*\n\n```dot render{\"id\": \"ab\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "renumbered",
+			doc:         "See [Figure 1](#fig-ab).\n\n```dot render{\"caption\": \"C\"}\ndigraph { a -> c }\n```\n\n<a id=\"fig-ab\"></a>" + imageB + "<br>*Figure 1: B*\n\n```dot render{\"caption\": \"B\", \"id\": \"ab\"}\ndigraph { a -> b }\n```",
+			want:        "See [Figure 2](#fig-ab).\n\n" + region("normal", "04579f4a", imageC+"<br>*Figure 1: C*\n\n```dot render{\"caption\": \"C\"}\ndigraph { a -> c }\n```") + "\n\n" + region("normal", "82682d8f", "<a id=\"fig-ab\"></a>"+imageB+"<br>*Figure 2: B*\n\n```dot render{\"caption\": \"B\", \"id\": \"ab\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name: "caption removed",
+			doc:  region("normal", "82682d8f", imageB+"<br>*Figure 1: B*\n\n```dot render\ndigraph { a -> b }\n```"),
+			want: region("normal", "82682d8f", imageB+"\n\n```dot render\ndigraph { a -> b }\n```"),
+		},
+		{
+			name: "unknown reference",
+			doc:  "See @fig:missing.",
+			want: "See @fig:missing.",
+		},
+		{
+			name:    "duplicate id",
+			doc:     "```dot render{\"id\": \"ab\"}\ndigraph { a -> b }\n```\n\n```dot render{\"id\": \"ab\"}\ndigraph { a -> c }\n```",
+			wantErr: true,
+		},
+		{
+			name:    "invalid id",
+			doc:     "```dot render{\"id\": \"a b\"}\ndigraph { a -> b }\n```",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renders int
+			opts := Options{
+				Languages:    []string{"dot"},
+				CaptionStyle: tt.captionStyle,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			renders = 0
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got || renders != 0 {
+				t.Errorf("Process() again =\n%s\nwith %d renders, want it unchanged", again, renders)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	figureListEndMarker = "<!-- /md-code-renderer:figures -->"
+
+	// Width of the thumbnails in lists of figures, in pixels
+	figureListThumbnailWidth = 120
+)
+
+// Match: <!-- md-code-renderer:figures -->, <!-- md-code-renderer:figures all -->
+// Capture group on the scope.
+var figureListMarkerRegexp = regexp.MustCompile(`^<!-- md-code-renderer:figures(?: (all))? -->$`)
+
+// FigureListEntry is an entry in a list of figures, which lists the rendered
+// images in a document or across the input set.
+type FigureListEntry struct {
+	Document      string // Link to the document containing the image, or an empty string for the document containing the list
+	Caption       string // Caption of the figure, or the alt text if the image isn't a figure
+	Anchor        string // Anchor of the figure, if it has an id
+	Heading       string // Text of the nearest heading above the image
+	HeadingAnchor string // Anchor of the nearest heading above the image
+	Image         string // Link to the image, used as a thumbnail. Empty for inlined images.
+}
+
+// FigureListEntry returns the chunk's entry in lists of figures. image is the
+// link to the chunk's image, relative to its document.
+func (r *Chunk) FigureListEntry(image string) FigureListEntry {
+	caption := r.AltText()
+	if r.IsFigure() {
+		caption = r.FigureCaption()
+	}
+	return FigureListEntry{
+		Caption:       caption,
+		Anchor:        r.FigureAnchor(),
+		Heading:       r.HeadingText,
+		HeadingAnchor: r.HeadingAnchor,
+		Image:         image,
+	}
+}
+
+// figureListEntries returns the entries of the rendered images among the
+// chunks, with the links read from their image lines.
+func figureListEntries(chunks []*Chunk) []FigureListEntry {
+	var entries []FigureListEntry
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		image, ok := chunk.parseImageLine(chunk.Lines[chunk.ImageRelativeLineIndex])
+		if !ok {
+			continue
+		}
+		var link string
+		if image.inline == "" {
+			link = image.link
+		}
+		entries = append(entries, chunk.FigureListEntry(link))
+	}
+	return entries
+}
+
+// updateFigureLists replaces the lists of figures in the lines of normal
+// chunks, which are marked by a comment such as
+// "<!-- md-code-renderer:figures -->". Lists marked with "all" list the
+// figures across the input set, returned by inputSetEntries.
+func updateFigureLists(chunks []*Chunk, inputSetEntries func() ([]FigureListEntry, error)) error {
+	var inCodeBlock bool
+	for _, chunk := range chunks {
+		if chunk.IsRenderable {
+			continue
+		}
+		var lines []string
+		for i := 0; i < len(chunk.Lines); i++ {
+			line := chunk.Lines[i]
+			lines = append(lines, line)
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				inCodeBlock = !inCodeBlock
+			}
+			matches := figureListMarkerRegexp.FindStringSubmatch(strings.TrimSpace(line))
+			if inCodeBlock || len(matches) != 2 {
+				continue
+			}
+
+			// Skip the previous list, if any
+			for j := i + 1; j < len(chunk.Lines); j++ {
+				next := strings.TrimSpace(chunk.Lines[j])
+				if figureListMarkerRegexp.MatchString(next) {
+					break
+				}
+				if next == figureListEndMarker {
+					i = j
+					break
+				}
+			}
+
+			var list []FigureListEntry
+			if matches[1] == "all" && inputSetEntries != nil {
+				var err error
+				list, err = inputSetEntries()
+				if err != nil {
+					return errors.Wrap(err, "list figures in input set")
+				}
+			} else {
+				list = figureListEntries(chunks)
+			}
+			lines = append(lines, buildFigureList(list)...)
+			lines = append(lines, figureListEndMarker)
+		}
+		chunk.Lines = lines
+	}
+	return nil
+}
+
+// buildFigureList returns the lines of a table listing the entries.
+func buildFigureList(entries []FigureListEntry) []string {
+	if len(entries) == 0 {
+		return nil
+	}
+	// Blank lines separate the table from the markers
+	lines := []string{
+		"",
+		"| Figure | Section | Thumbnail |",
+		"| --- | --- | --- |",
+	}
+	for _, v := range entries {
+		caption := escapeTableCell(v.Caption)
+		if v.Anchor != "" {
+			caption = fmt.Sprintf("[%s](%s#%s)", caption, v.Document, v.Anchor)
+		}
+		section := v.Heading
+		if v.Document != "" {
+			section = strings.TrimSuffix(v.Document+": "+v.Heading, ": ")
+		}
+		link := v.Document
+		if v.HeadingAnchor != "" {
+			link += "#" + v.HeadingAnchor
+		}
+		if section != "" && link != "" {
+			section = fmt.Sprintf("[%s](%s)", escapeTableCell(section), link)
+		} else {
+			section = escapeTableCell(section)
+		}
+		var thumbnail string
+		if v.Image != "" {
+			thumbnail = fmt.Sprintf(`<img src="%s" alt="" width="%d">`, html.EscapeString(v.Image), figureListThumbnailWidth)
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s |", caption, section, thumbnail))
+	}
+	return append(lines, "")
+}
+
+func escapeTableCell(text string) string {
+	return strings.ReplaceAll(markdownAltEscaper.Replace(text), "|", `\|`)
+}
+
+// headingAnchor returns the anchor that GitHub generates for a heading:
+// lowercase, with punctuation removed and spaces replaced by hyphens.
+func headingAnchor(heading string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(heading) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// uniqueHeadingAnchor returns the anchor of the heading, suffixed with a number
+// if an earlier heading has the same anchor, as GitHub does. counts holds the
+// number of earlier headings with each anchor.
+func uniqueHeadingAnchor(counts map[string]int, heading string) string {
+	anchor := headingAnchor(heading)
+	count := counts[anchor]
+	counts[anchor]++
+	if count > 0 {
+		return fmt.Sprintf("%s-%d", anchor, count)
+	}
+	return anchor
+}
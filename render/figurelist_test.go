@@ -0,0 +1,113 @@
+package render
+
+import (
+	"testing"
+)
+
+func TestProcessFigureList(t *testing.T) {
+	const (
+		marker  = "<!-- md-code-renderer:figures -->"
+		end     = "<!-- /md-code-renderer:figures -->"
+		header  = "| Figure | Section | Thumbnail |\n| --- | --- | --- |"
+		imageB  = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		blockB  = "```dot render{\"caption\": \"B | C\", \"id\": \"b\"}\ndigraph { a -> b }\n```"
+		figureB = "<a id=\"fig-b\"></a>" + imageB + "<br>*Figure 1: B | C*"
+		regionB = "<!-- md-code-renderer:begin mode=normal hash=82682d8f -->\n" + figureB + "\n\n" + blockB + "\n<!-- md-code-renderer:end -->"
+		entryB  = "| [Figure 1: B \\| C](#fig-b) | [Auth flow](#auth-flow) | <img src=\"render-82682d8f229ac783001529cc84b0b85b.svg\" alt=\"\" width=\"120\"> |"
+	)
+	tests := []struct {
+		name            string
+		doc             string
+		inputSetFigures []FigureListEntry
+		want            string
+	}{
+		{
+			name: "document",
+			doc:  marker + "\n\n# Auth flow\n\n" + blockB,
+			want: marker + "\n\n" + header + "\n" + entryB + "\n\n" + end + "\n\n# Auth flow\n\n" + regionB,
+		},
+		{
+			name: "previous list replaced",
+			doc:  marker + "\n\n| Old |\n\n" + end + "\n\n# Auth flow\n\n" + regionB,
+			want: marker + "\n\n" + header + "\n" + entryB + "\n\n" + end + "\n\n# Auth flow\n\n" + regionB,
+		},
+		{
+			name: "not a figure",
+			doc:  marker + "\n\n## Setup\n\n## Setup\n\n```dot render\ndigraph { a -> b }\n```",
+			want: marker + "\n\n" + header + "\n| Graphviz diagram | [Setup](#setup-1) | <img src=\"render-82682d8f229ac783001529cc84b0b85b.svg\" alt=\"\" width=\"120\"> |\n\n" + end + "\n\n## Setup\n\n## Setup\n\n" + region("normal", "82682d8f", imageB+"\n\n```dot render\ndigraph { a -> b }\n```"),
+		},
+		{
+			name: "no images",
+			doc:  marker,
+			want: marker + "\n" + end,
+		},
+		{
+			name: "marker in code block",
+			doc:  "```\n" + marker + "\n```",
+			want: "```\n" + marker + "\n```",
+		},
+		{
+			name: "input set",
+			doc:  "<!-- md-code-renderer:figures all -->",
+			inputSetFigures: []FigureListEntry{
+				{Caption: "Graphviz diagram", Heading: "Intro", HeadingAnchor: "intro", Image: "a.svg"},
+				{Document: "docs/b.md", Caption: "Figure 1", Anchor: "fig-b"},
+				{Document: "docs/c.md", Caption: "Graphviz diagram", Heading: "Usage", HeadingAnchor: "usage"},
+			},
+			want: "<!-- md-code-renderer:figures all -->\n\n" + header + "\n" +
+				"| Graphviz diagram | [Intro](#intro) | <img src=\"a.svg\" alt=\"\" width=\"120\"> |\n" +
+				"| [Figure 1](docs/b.md#fig-b) | [docs/b.md](docs/b.md) |  |\n" +
+				"| Graphviz diagram | [docs/c.md: Usage](docs/c.md#usage) |  |\n\n" + end,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{
+				Languages: []string{"dot"},
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			}
+			if tt.inputSetFigures != nil {
+				opts.InputSetFigures = func() ([]FigureListEntry, error) {
+					return tt.inputSetFigures, nil
+				}
+			}
+			got, err := Process(tt.doc, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got {
+				t.Errorf("Process() again =\n%s\nwant it unchanged", again)
+			}
+		})
+	}
+}
+
+func TestUniqueHeadingAnchor(t *testing.T) {
+	counts := make(map[string]int)
+	tests := []struct {
+		heading string
+		want    string
+	}{
+		{"Auth flow", "auth-flow"},
+		{"What's new?", "whats-new"},
+		{"snake_case & kebab-case", "snake_case--kebab-case"},
+		{"Auth flow", "auth-flow-1"},
+		{"Auth flow", "auth-flow-2"},
+	}
+	for _, tt := range tests {
+		if got := uniqueHeadingAnchor(counts, tt.heading); got != tt.want {
+			t.Errorf("uniqueHeadingAnchor(%q) = %q, want %q", tt.heading, got, tt.want)
+		}
+	}
+}
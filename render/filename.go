@@ -0,0 +1,101 @@
+package render
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// FilenameTemplateData is the data available to filename templates.
+type FilenameTemplateData struct {
+	Stem      string // Filename of the markdown document, without its extension
+	Index     int    // 1-based index of the code block among renderable code blocks in the document
+	Hash      string // Hash of the code block's content
+	ShortHash string // First 8 characters of Hash
+	Ext       string // Extension of the rendered image, without the leading dot
+	Language  string // Language of the code block
+	Heading   string // Slug of the nearest heading above the code block
+}
+
+// ParseFilenameTemplate parses and validates a template for image filenames.
+func ParseFilenameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse filename template")
+	}
+	sample := FilenameTemplateData{
+		Stem:      "doc",
+		Index:     1,
+		Hash:      strings.Repeat("0", 32),
+		ShortHash: strings.Repeat("0", 8),
+		Ext:       "svg",
+		Language:  "dot",
+		Heading:   "heading",
+	}
+	name, err := executeFilenameTemplate(tmpl, sample)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, errors.New("filename template must produce a filename without directories")
+	}
+	byIndex, byHash := sample, sample
+	byIndex.Index = 2
+	byHash.Hash, byHash.ShortHash = strings.Repeat("1", 32), strings.Repeat("1", 8)
+	for _, v := range []FilenameTemplateData{byIndex, byHash} {
+		other, err := executeFilenameTemplate(tmpl, v)
+		if err != nil {
+			return nil, err
+		}
+		if other != name {
+			return tmpl, nil
+		}
+	}
+	return nil, errors.New("filename template must reference .Hash, .ShortHash or .Index, so that filenames are unique")
+}
+
+func executeFilenameTemplate(tmpl *template.Template, data FilenameTemplateData) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		return "", errors.Wrap(err, "execute filename template")
+	}
+	return b.String(), nil
+}
+
+func (r *Chunk) filenameTemplateData(ext string) FilenameTemplateData {
+	hash := r.HashContent()
+	name := filepath.Base(r.DocumentName)
+	return FilenameTemplateData{
+		Stem:      strings.TrimSuffix(name, filepath.Ext(name)),
+		Index:     r.Index,
+		Hash:      hash,
+		ShortHash: hash[:8],
+		Ext:       ext,
+		Language:  r.Language,
+		Heading:   r.Heading,
+	}
+}
+
+var (
+	atxHeadingRegexp  = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+	slugInvalidRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// parseHeading returns the text of an ATX heading, e.g. "## Auth flow".
+func parseHeading(line string) (heading string, ok bool) {
+	matches := atxHeadingRegexp.FindStringSubmatch(line)
+	if len(matches) != 2 {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// slugify converts text to a lowercase, hyphen-separated slug suitable for
+// filenames.
+func slugify(text string) string {
+	return strings.Trim(slugInvalidRegexp.ReplaceAllString(strings.ToLower(text), "-"), "-")
+}
@@ -0,0 +1,187 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr bool
+	}{
+		{text: "{{.Stem}}-{{.Index}}-{{.ShortHash}}.{{.Ext}}"},
+		{text: "{{.Language}}-{{.Heading}}-{{.Hash}}.svg"},
+		{text: "diagram.svg", wantErr: true},
+		{text: "{{.Heading}}.{{.Ext}}", wantErr: true},
+		{text: "{{.Stem", wantErr: true},
+		{text: "{{.Bogus}}.svg", wantErr: true},
+		{text: "images/{{.Hash}}.svg", wantErr: true},
+		{text: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := ParseFilenameTemplate(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFilenameTemplate(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProcessFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		doc         string
+		want        string
+		wantRenders int
+	}{
+		{
+			name:        "stem and index",
+			template:    "{{.Stem}}-{{.Index}}-{{.ShortHash}}.{{.Ext}}",
+			doc:         "```dot render\ndigraph { a -> b }\n```\n\n```dot render\ndigraph { a -> c }\n```",
+			want:        region("normal", "82682d8f", "![Graphviz diagram](guide-1-82682d8f.svg)\n\n```dot render\ndigraph { a -> b }\n```") + "\n\n" + region("normal", "04579f4a", "![Graphviz diagram](guide-2-04579f4a.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			wantRenders: 2,
+		},
+		{
+			name:        "heading",
+			template:    "{{.Heading}}-{{.Index}}.{{.Ext}}",
+			doc:         "# Auth flow\n\n```dot render\ndigraph { a -> b }\n```\n\n## Token refresh!\n\n```\n# Not a heading\n```\n\n```dot render\ndigraph { a -> c }\n```",
+			want:        "# Auth flow\n\n" + region("normal", "82682d8f", "![Graphviz diagram](auth-flow-1.svg)\n\n```dot render\ndigraph { a -> b }\n```") + "\n\n## Token refresh!\n\n```\n# Not a heading\n```\n\n" + region("normal", "04579f4a", "![Graphviz diagram](token-refresh-2.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			wantRenders: 2,
+		},
+		{
+			name:        "code changed",
+			template:    "{{.Stem}}-{{.Index}}.{{.Ext}}",
+			doc:         region("normal", "82682d8f", "![Graphviz diagram](guide-1.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			want:        region("normal", "04579f4a", "![Graphviz diagram](guide-1.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "legacy hash comment",
+			template:    "{{.Stem}}-{{.Index}}.{{.Ext}}",
+			doc:         "![Graphviz diagram](guide-1.svg) <!-- hash:82682d8f -->\n\n```dot render\ndigraph { a -> c }\n```",
+			want:        region("normal", "04579f4a", "![Graphviz diagram](guide-1.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "legacy layout recorded in the manifest",
+			template:    "{{.Stem}}-{{.Index}}.{{.Ext}}",
+			doc:         "![Graphviz diagram](recorded.svg)\n\n```dot render\ndigraph { a -> b }\n```",
+			want:        region("normal", "82682d8f", "![Graphviz diagram](guide-1.svg)\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "image above a new code block",
+			template:    "{{.Stem}}-{{.Index}}.{{.Ext}}",
+			doc:         "![Photo](photo.svg)\n\n```dot render\ndigraph { a -> b }\n```",
+			want:        "![Photo](photo.svg)\n\n" + region("normal", "82682d8f", "![Graphviz diagram](guide-1.svg)\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "image above a new code block with a filename",
+			doc:         "![Photo](photo.svg)\n\n```dot render{\"filename\": \"flow.svg\"}\ndigraph { a -> b }\n```",
+			want:        "![Photo](photo.svg)\n\n" + region("normal", "82682d8f", "![Graphviz diagram](flow.svg)\n\n```dot render{\"filename\": \"flow.svg\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := Manifest{Files: []string{"recorded.svg"}}.Write(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var renders int
+			opts := Options{
+				Name:             filepath.Join(dir, "guide.md"),
+				Languages:        []string{"dot"},
+				FilenameTemplate: tt.template,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+
+			// Rendering again is a no-op, as the hashes match
+			renders = 0
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got || renders != 0 {
+				t.Errorf("Process() again =\n%s\nwith %d renders, want it unchanged", again, renders)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Auth flow", "auth-flow"},
+		{"Token refresh!", "token-refresh"},
+		{"  API / v2  ", "api-v2"},
+		{"Ünïcode", "n-code"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.text); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, ManifestFilename)
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 0 {
+		t.Fatalf("missing manifest has files %v", m.Files)
+	}
+
+	for _, v := range []string{"b.svg", "a.svg", "b.svg"} {
+		err := recordGeneratedImage(dir, v, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err = ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.svg", "b.svg"}; !reflect.DeepEqual(m.Files, want) {
+		t.Errorf("manifest files = %v, want %v", m.Files, want)
+	}
+	if !m.Contains("a.svg") || m.Contains("c.svg") {
+		t.Errorf("Contains() is wrong for %v", m.Files)
+	}
+
+	m.Remove("a.svg")
+	m.Remove("b.svg")
+	err = m.Write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Errorf("empty manifest was not removed: %v", err)
+	}
+}
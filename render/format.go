@@ -0,0 +1,162 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultFormat = "svg"
+
+// Formats lists the supported output formats.
+var Formats = []string{"svg", "png", "jpeg", "pdf", "webp"}
+
+// formatArgs maps the formats supported by each language's renderer to the
+// arguments that select them.
+var formatArgs = map[string]map[string][]string{
+	"dot": {
+		"svg":  {"-Tsvg"},
+		"png":  {"-Tpng"},
+		"jpeg": {"-Tjpeg"},
+		"pdf":  {"-Tpdf"},
+		"webp": {"-Twebp"},
+	},
+	"plantuml": {
+		"svg": {"-tsvg"},
+		"png": {"-tpng"},
+		"pdf": {"-tpdf"},
+	},
+	"pikchr": {
+		"svg": {"--svg-only"},
+	},
+}
+
+// FormatList is a list of output formats. The first format is the primary
+// format, which the document links to. In JSON, a single format may be given
+// as a string.
+type FormatList []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *FormatList) UnmarshalJSON(b []byte) error {
+	var format string
+	if err := json.Unmarshal(b, &format); err == nil {
+		*l = FormatList{format}
+		return nil
+	}
+	var formats []string
+	err := json.Unmarshal(b, &formats)
+	if err != nil {
+		return errors.New("format must be a string or a list of strings")
+	}
+	*l = formats
+	return nil
+}
+
+// Validate returns an error if the list contains unsupported or duplicate
+// formats.
+func (l FormatList) Validate() error {
+	seen := make(map[string]bool)
+	for _, v := range l {
+		err := ValidateFormat(v)
+		if err != nil {
+			return err
+		}
+		if seen[v] {
+			return fmt.Errorf("duplicate format %q", v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+// ValidateFormat returns an error if format is not a supported output format.
+func ValidateFormat(format string) error {
+	if !isFormat(format) {
+		return fmt.Errorf("unsupported format %q, must be one of: %s", format, strings.Join(Formats, ", "))
+	}
+	return nil
+}
+
+func isFormat(format string) bool {
+	for _, v := range Formats {
+		if v == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns the primary format the chunk is rendered to, which the
+// document links to.
+func (r *Chunk) Format() string {
+	return r.Formats()[0]
+}
+
+// Formats returns the formats the chunk is rendered to, starting with the
+// primary format. If the chunk has a format option, those formats are used.
+// If the extension of its filename option gives the format, that format is
+// used, followed by the extra formats that the renderer supports. Otherwise
+// the document's default formats that the renderer supports are used. If
+// there are none, svg is used.
+func (r *Chunk) Formats() []string {
+	if len(r.RenderOptions.Format) > 0 {
+		return r.RenderOptions.Format
+	}
+	if format, ok := formatForExt(filepath.Ext(r.RenderOptions.Filename)); ok {
+		formats := []string{format}
+		for _, v := range r.ExtraFormats {
+			if supportsFormat(r.Language, v) && v != format {
+				formats = append(formats, v)
+			}
+		}
+		return formats
+	}
+	var formats []string
+	for _, v := range r.DefaultFormats {
+		if supportsFormat(r.Language, v) {
+			formats = append(formats, v)
+		}
+	}
+	if len(formats) == 0 {
+		return []string{defaultFormat}
+	}
+	return formats
+}
+
+// formatForExt returns the format of files with the extension, if any.
+func formatForExt(ext string) (string, bool) {
+	format := strings.TrimPrefix(ext, ".")
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	return format, isFormat(format)
+}
+
+// supportsFormat returns whether the language's renderer can render format,
+// either itself or by rasterizing its SVG.
+func supportsFormat(language string, format string) bool {
+	_, _, err := rendererFormatArgs(language, format)
+	return err == nil
+}
+
+// rendererFormatArgs returns the arguments that select format for the
+// language's renderer. If the renderer doesn't support format, but it can be
+// rasterized from SVG, the arguments select SVG and rasterize is true.
+func rendererFormatArgs(language string, format string) (args []string, rasterize bool, err error) {
+	formats, ok := formatArgs[language]
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported type: %s", language)
+	}
+	if args, ok := formats[format]; ok {
+		return args, false, nil
+	}
+	for _, v := range rasterizedFormats {
+		if v == format {
+			return formats["svg"], true, nil
+		}
+	}
+	return nil, false, errors.Errorf("%s does not support the %s format", language, format)
+}
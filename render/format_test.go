@@ -0,0 +1,209 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		language      string
+		renderOptions RenderOptions
+		defaultFormat string
+		want          string
+		wantFileName  string
+	}{
+		{name: "default", language: "dot", want: "svg", wantFileName: "render-82682d8f229ac783001529cc84b0b85b.svg"},
+		{name: "default format", language: "dot", defaultFormat: "png", want: "png", wantFileName: "render-82682d8f229ac783001529cc84b0b85b.png"},
+		{name: "default format unsupported by renderer", language: "pikchr", defaultFormat: "pdf", want: "svg"},
+		{name: "default format rasterized", language: "pikchr", defaultFormat: "png", want: "png"},
+		{name: "format option", language: "dot", renderOptions: RenderOptions{Format: FormatList{"pdf"}}, defaultFormat: "png", want: "pdf", wantFileName: "render-82682d8f229ac783001529cc84b0b85b.pdf"},
+		{name: "filename extension", language: "dot", renderOptions: RenderOptions{Filename: "a.webp"}, defaultFormat: "png", want: "webp", wantFileName: "a.webp"},
+		{name: "jpg filename extension", language: "dot", renderOptions: RenderOptions{Filename: "a.jpg"}, want: "jpeg", wantFileName: "a.jpg"},
+		{name: "unknown filename extension", language: "dot", renderOptions: RenderOptions{Filename: "a.gif"}, defaultFormat: "png", want: "png", wantFileName: "a.gif"},
+		{name: "format option over filename", language: "dot", renderOptions: RenderOptions{Filename: "a.svg", Format: FormatList{"png"}}, want: "png", wantFileName: "a.svg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := NewChunk(tt.language, "digraph { a -> b }", tt.renderOptions)
+			if tt.defaultFormat != "" {
+				chunk.DefaultFormats = []string{tt.defaultFormat}
+			}
+			if got := chunk.Format(); got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+			if tt.wantFileName != "" && chunk.FileName() != tt.wantFileName {
+				t.Errorf("FileName() = %q, want %q", chunk.FileName(), tt.wantFileName)
+			}
+		})
+	}
+}
+
+func TestProcessFormat(t *testing.T) {
+	const hash = "82682d8f229ac783001529cc84b0b85b"
+	tests := []struct {
+		name          string
+		doc           string
+		defaultFormat string
+		want          string
+		wantRenders   int
+		wantErr       bool
+	}{
+		{
+			name:        "format option",
+			doc:         "```dot render{\"format\": \"png\"}\ndigraph { a -> b }\n```",
+			want:        region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".png)\n\n```dot render{\"format\": \"png\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:          "default format",
+			doc:           "```dot render\ndigraph { a -> b }\n```",
+			defaultFormat: "webp",
+			want:          region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".webp)\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders:   1,
+		},
+		{
+			name:          "format changed",
+			doc:           region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".svg)\n\n```dot render\ndigraph { a -> b }\n```"),
+			defaultFormat: "png",
+			want:          region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".png)\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders:   1,
+		},
+		{
+			name: "format unchanged",
+			doc:  region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".png)\n\n```dot render{\"format\": \"png\"}\ndigraph { a -> b }\n```"),
+			want: region("normal", "82682d8f", "![Graphviz diagram](render-"+hash+".png)\n\n```dot render{\"format\": \"png\"}\ndigraph { a -> b }\n```"),
+		},
+		{
+			name:    "unsupported format option",
+			doc:     "```dot render{\"format\": \"gif\"}\ndigraph { a -> b }\n```",
+			wantErr: true,
+		},
+		{
+			name:    "format unsupported by renderer",
+			doc:     "```pikchr render{\"format\": \"pdf\"}\nbox\n```",
+			wantErr: true,
+		},
+		{
+			name:          "unsupported default format",
+			doc:           "```dot render\ndigraph { a -> b }\n```",
+			defaultFormat: "gif",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renders int
+			var defaultFormats []string
+			if tt.defaultFormat != "" {
+				defaultFormats = []string{tt.defaultFormat}
+			}
+			got, err := Process(tt.doc, Options{
+				Languages:      []string{"dot", "pikchr"},
+				DefaultFormats: defaultFormats,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+		})
+	}
+}
+
+func TestChunkFormats(t *testing.T) {
+	tests := []struct {
+		name           string
+		info           string
+		defaultFormats []string
+		extraFormats   []string
+		wantFileNames  []string
+		wantErr        bool
+	}{
+		{
+			name:          "default",
+			info:          "dot render",
+			wantFileNames: []string{"render-82682d8f229ac783001529cc84b0b85b.svg"},
+		},
+		{
+			name:           "default formats",
+			info:           "dot render",
+			defaultFormats: []string{"svg", "png"},
+			wantFileNames:  []string{"render-82682d8f229ac783001529cc84b0b85b.svg", "render-82682d8f229ac783001529cc84b0b85b.png"},
+		},
+		{
+			name:           "default formats unsupported by renderer",
+			info:           "pikchr render",
+			defaultFormats: []string{"pdf", "svg"},
+			wantFileNames:  []string{"render-82682d8f229ac783001529cc84b0b85b.svg"},
+		},
+		{
+			name:           "format list",
+			info:           `dot render{"format": ["png", "pdf"]}`,
+			defaultFormats: []string{"svg"},
+			wantFileNames:  []string{"render-82682d8f229ac783001529cc84b0b85b.png", "render-82682d8f229ac783001529cc84b0b85b.pdf"},
+		},
+		{
+			name:           "filename ignores default formats",
+			info:           `dot render{"filename": "custom.png"}`,
+			defaultFormats: []string{"svg"},
+			wantFileNames:  []string{"custom.png"},
+		},
+		{
+			name:           "filename with extra formats",
+			info:           `pikchr render{"filename": "custom.png"}`,
+			defaultFormats: []string{"svg", "pdf"},
+			extraFormats:   []string{"svg", "png", "pdf"},
+			wantFileNames:  []string{"custom.png", "custom.svg"},
+		},
+		{
+			name:           "format option ignores extra formats",
+			info:           `dot render{"format": "png", "filename": "custom.png"}`,
+			defaultFormats: []string{"svg"},
+			extraFormats:   []string{"svg"},
+			wantFileNames:  []string{"custom.png"},
+		},
+		{
+			name:          "format list with filename",
+			info:          `dot render{"format": ["png", "svg"], "filename": "flow.png"}`,
+			wantFileNames: []string{"flow.png", "flow.svg"},
+		},
+		{
+			name:    "duplicate format",
+			info:    `dot render{"format": ["png", "png"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid format list",
+			info:    `dot render{"format": 1}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			language, renderOptions, _, err := ParseInfo(tt.info, []string{"dot", "pikchr"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInfo() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			chunk := NewChunk(language, "digraph { a -> b }", renderOptions)
+			chunk.DefaultFormats = tt.defaultFormats
+			chunk.ExtraFormats = tt.extraFormats
+			if got := chunk.FileNames(); !reflect.DeepEqual(got, tt.wantFileNames) {
+				t.Errorf("FileNames() = %v, want %v", got, tt.wantFileNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Package goldmarkext is a goldmark extension that renders code blocks marked
+// with the render keyword into images during HTML conversion.
+//
+//	md := goldmark.New(goldmark.WithExtensions(&goldmarkext.Extender{
+//		Languages:  []string{"dot", "plantuml", "pikchr"},
+//		OutputDir:  "public/diagrams",
+//		LinkPrefix: "/diagrams/",
+//	}))
+package goldmarkext
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Extender renders fenced code blocks such as ```dot render into images. The
+// render modes behave as they do for markdown output, with the collapsed
+// modes using <details> elements.
+type Extender struct {
+	Languages  []string // Languages to render
+	OutputDir  string   // Directory to write rendered images to
+	LinkPrefix string   // Prefix to use when linking to rendered images
+
+	// DefaultFormats are the formats to render code blocks to, if they
+	// don't specify any. The first format is embedded or linked to. If
+	// empty, svg is used.
+	DefaultFormats []string
+
+	// Dark renders a dark variant of each image, if code blocks don't
+	// specify otherwise. Images with dark variants are displayed with
+	// <picture> elements.
+	Dark bool
+
+	SVG    render.SVGOptions    // Post-processing of rendered SVGs
+	Raster render.RasterOptions // Options for raster images
+
+	// Inline embeds images in the HTML instead of writing them to
+	// OutputDir. SVGs are embedded as-is, other formats as data URIs.
+	Inline bool
+}
+
+// Extend implements goldmark.Extender.
+func (e *Extender) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&diagramTransformer{languages: e.Languages, defaultFormats: e.DefaultFormats, dark: e.Dark, svg: e.SVG, raster: e.Raster}, 100),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&diagramRenderer{extender: e, cache: make(map[string][]byte)}, 100),
+	))
+}
+
+// KindDiagram is the NodeKind of Diagram nodes.
+var KindDiagram = ast.NewNodeKind("Diagram")
+
+// Diagram is a block node wrapping a fenced code block that is to be
+// rendered. The code block is the node's only child.
+type Diagram struct {
+	ast.BaseBlock
+	Chunk *render.Chunk
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Diagram) Kind() ast.NodeKind {
+	return KindDiagram
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Diagram) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Language": n.Chunk.Language,
+		"Mode":     n.Chunk.RenderOptions.Mode,
+	}, nil)
+}
+
+// diagramTransformer wraps renderable fenced code blocks in Diagram nodes.
+type diagramTransformer struct {
+	languages      []string
+	defaultFormats []string
+	dark           bool
+	svg            render.SVGOptions
+	raster         render.RasterOptions
+}
+
+func (t *diagramTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Collect the code blocks first, since the tree can't be modified
+	// while walking it.
+	var codeBlocks []*ast.FencedCodeBlock
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if codeBlock, ok := node.(*ast.FencedCodeBlock); ok && entering {
+			codeBlocks = append(codeBlocks, codeBlock)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var figureCount int
+	for _, codeBlock := range codeBlocks {
+		if codeBlock.Info == nil {
+			continue
+		}
+		info := string(codeBlock.Info.Segment.Value(source))
+		language, renderOptions, ok, err := render.ParseInfo(info, t.languages)
+		if err != nil || !ok {
+			// Invalid options leave the code block as-is
+			continue
+		}
+
+		var content bytes.Buffer
+		lines := codeBlock.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			content.Write(line.Value(source))
+		}
+		// Match the content hashed by the CLI, so that images rendered
+		// by either are reused.
+		chunk := render.NewChunk(language, strings.TrimSuffix(content.String(), "\n"), renderOptions)
+		chunk.DefaultFormats = t.defaultFormats
+		chunk.DefaultDark = t.dark
+		chunk.SVGOptions = t.svg
+		chunk.RasterOptions = t.raster
+		if chunk.IsFigure() {
+			figureCount++
+			chunk.FigureNumber = figureCount
+		}
+
+		diagram := &Diagram{Chunk: chunk}
+		parent := codeBlock.Parent()
+		parent.ReplaceChild(parent, codeBlock, diagram)
+		diagram.AppendChild(diagram, codeBlock)
+	}
+}
+
+// diagramRenderer renders Diagram nodes to HTML. Rendered images are cached
+// by the hash of their code block.
+type diagramRenderer struct {
+	extender *Extender
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func (r *diagramRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindDiagram, r.renderDiagram)
+}
+
+// renderDiagram writes the image before or after the code block, depending
+// on the render mode. The code block itself is rendered by its own renderer.
+func (r *diagramRenderer) renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Diagram)
+	mode := n.Chunk.RenderOptions.Mode
+	if entering {
+		switch mode {
+		case "image-collapsed", "image-below":
+			return ast.WalkContinue, nil
+		case "side-by-side":
+			w.WriteString("<table><tr><td>\n")
+			return ast.WalkContinue, nil
+		case "code-hidden", "replace":
+			return ast.WalkSkipChildren, r.writeImage(w, n.Chunk)
+		}
+		err := r.writeImage(w, n.Chunk)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		if mode == "code-collapsed" {
+			w.WriteString("<details><summary>Source</summary>\n")
+		}
+		return ast.WalkContinue, nil
+	}
+
+	switch mode {
+	case "code-collapsed":
+		w.WriteString("</details>\n")
+	case "image-collapsed":
+		w.WriteString("<details><summary>Image</summary>\n")
+		err := r.writeImage(w, n.Chunk)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		w.WriteString("</details>\n")
+	case "image-below":
+		err := r.writeImage(w, n.Chunk)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+	case "side-by-side":
+		w.WriteString("</td><td>\n")
+		err := r.writeImage(w, n.Chunk)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		w.WriteString("</td></tr></table>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *diagramRenderer) writeImage(w util.BufWriter, chunk *render.Chunk) error {
+	fileName := chunk.FileName()
+	content, err := r.render(chunk, false)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("render %s", fileName))
+	}
+	var darkContent []byte
+	if chunk.HasDarkVariant() {
+		darkContent, err = r.render(chunk, true)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("render %s", render.DarkFileName(fileName)))
+		}
+	}
+
+	inline := r.inline(chunk)
+	alt, title := chunk.AltText(), chunk.Title()
+	if chunk.IsFigure() {
+		if anchor := chunk.FigureAnchor(); anchor != "" {
+			fmt.Fprintf(w, `<figure class="md-code-renderer" id="%s">`, anchor)
+		} else {
+			w.WriteString(`<figure class="md-code-renderer">`)
+		}
+	}
+	switch {
+	case inline && chunk.HasDarkVariant():
+		w.WriteString(render.BuildPicture(alt, title, render.DataURI(fileName, content), render.DataURI(fileName, darkContent)))
+	case chunk.HasDarkVariant():
+		w.WriteString(render.BuildPicture(alt, title, r.extender.LinkPrefix+fileName, r.extender.LinkPrefix+render.DarkFileName(fileName)))
+	case inline && chunk.Inline() != "data-uri" && path.Ext(fileName) == ".svg":
+		w.Write(render.StripXMLProlog(content))
+	case inline:
+		fmt.Fprintf(w, `<img src="%s" alt="%s"%s>`, render.DataURI(fileName, content), html.EscapeString(alt), imgTitleAttr(title))
+	default:
+		fmt.Fprintf(w, `<img src="%s" alt="%s"%s>`, html.EscapeString(r.extender.LinkPrefix+fileName), html.EscapeString(alt), imgTitleAttr(title))
+	}
+	if chunk.IsFigure() {
+		fmt.Fprintf(w, "<figcaption>%s</figcaption></figure>", html.EscapeString(chunk.FigureCaption()))
+	}
+	w.WriteString("\n")
+	return nil
+}
+
+// inline returns whether the chunk's image is embedded in the HTML, either
+// because of the extender's Inline option or the code block's inline option.
+func (r *diagramRenderer) inline(chunk *render.Chunk) bool {
+	return r.extender.Inline || chunk.Inline() != ""
+}
+
+// render returns the rendered image for the chunk, or its dark variant. When
+// writing to files, only the images that changed are rendered, and no content
+// is returned.
+func (r *diagramRenderer) render(chunk *render.Chunk, dark bool) ([]byte, error) {
+	renderFormat := chunk.RenderFormat
+	fileNameForFormat := chunk.FileNameForFormat
+	if dark {
+		renderFormat = chunk.RenderDarkFormat
+		fileNameForFormat = func(format string) string {
+			return render.DarkFileName(chunk.FileNameForFormat(format))
+		}
+	}
+	fileName := fileNameForFormat(chunk.Format())
+	key := chunk.RenderKey(chunk.Format(), dark) + "/" + fileName
+	r.mu.Lock()
+	content, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+
+	if r.inline(chunk) {
+		var err error
+		content, err = renderFormat(chunk.Format())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Every format is written, though only the primary format is
+		// linked to.
+		for _, format := range chunk.Formats() {
+			_, err := chunk.WriteImageIfChanged(r.extender.OutputDir, fileNameForFormat(format), format, dark)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	r.mu.Lock()
+	r.cache[key] = content
+	r.mu.Unlock()
+	return content, nil
+}
+
+// imgTitleAttr returns the title attribute of an <img> element, or an empty
+// string if there is no title.
+func imgTitleAttr(title string) string {
+	if title == "" {
+		return ""
+	}
+	return fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+}
@@ -0,0 +1,143 @@
+package goldmarkext
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+const (
+	testSVG   = `<svg xmlns="http://www.w3.org/2000/svg"></svg>`
+	testImage = "render-82682d8f229ac783001529cc84b0b85b.svg"
+	testCode  = "<pre><code class=\"language-dot\">digraph { a -&gt; b }\n</code></pre>\n"
+)
+
+// fakeRenderers puts stand-ins for the renderers on the PATH, which output
+// testSVG with an XML declaration.
+func fakeRenderers(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake renderers are shell scripts")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat >/dev/null\necho '<?xml version=\"1.0\"?>'\necho '" + testSVG + "'\n"
+	for _, v := range []string{"dot", "plantuml", "pikchr"} {
+		err := os.WriteFile(filepath.Join(dir, v), []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestExtender(t *testing.T) {
+	image := `<img src="/diagrams/` + testImage + `" alt="Graphviz diagram">` + "\n"
+	tests := []struct {
+		name    string
+		options string
+		inline  bool
+		want    string
+	}{
+		{
+			name: "normal",
+			want: image + testCode,
+		},
+		{
+			name:    "code-collapsed",
+			options: `{"mode": "code-collapsed"}`,
+			want:    image + "<details><summary>Source</summary>\n" + testCode + "</details>\n",
+		},
+		{
+			name:    "image-collapsed",
+			options: `{"mode": "image-collapsed"}`,
+			want:    testCode + "<details><summary>Image</summary>\n" + image + "</details>\n",
+		},
+		{
+			name:    "code-hidden",
+			options: `{"mode": "code-hidden"}`,
+			want:    image,
+		},
+		{
+			name:    "image-below",
+			options: `{"mode": "image-below"}`,
+			want:    testCode + image,
+		},
+		{
+			name:    "side-by-side",
+			options: `{"mode": "side-by-side"}`,
+			want:    "<table><tr><td>\n" + testCode + "</td><td>\n" + image + "</td></tr></table>\n",
+		},
+		{
+			name:   "inline svg",
+			inline: true,
+			want:   `<svg xmlns="http://www.w3.org/2000/svg" role="img"><title>Graphviz diagram</title></svg>` + "\n\n" + testCode,
+		},
+		{
+			name:    "figure",
+			options: `{"caption": "Flow", "id": "flow"}`,
+			want:    `<figure class="md-code-renderer" id="fig-flow"><img src="/diagrams/` + testImage + `" alt="Graphviz diagram"><figcaption>Figure 1: Flow</figcaption></figure>` + "\n" + testCode,
+		},
+		{
+			name:    "invalid options",
+			options: `{"format": "gif"}`,
+			want:    "<pre><code class=\"language-dot\">digraph { a -&gt; b }\n</code></pre>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRenderers(t)
+			outputDir := t.TempDir()
+			md := goldmark.New(goldmark.WithExtensions(&Extender{
+				Languages:  []string{"dot"},
+				OutputDir:  outputDir,
+				LinkPrefix: "/diagrams/",
+				Inline:     tt.inline,
+			}))
+			var b bytes.Buffer
+			err := md.Convert([]byte("```dot render"+tt.options+"\ndigraph { a -> b }\n```\n"), &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Convert() =\n%s\nwant\n%s", got, tt.want)
+			}
+
+			_, err = os.Stat(filepath.Join(outputDir, testImage))
+			if wantFile := strings.Contains(tt.want, `src="/diagrams/`); (err == nil) != wantFile {
+				t.Errorf("image written = %v, want %v", err == nil, wantFile)
+			}
+		})
+	}
+}
+
+func TestExtenderRenderOnlyChanged(t *testing.T) {
+	fakeRenderers(t)
+	outputDir := t.TempDir()
+	convert := func(options string) string {
+		t.Helper()
+		md := goldmark.New(goldmark.WithExtensions(&Extender{Languages: []string{"dot"}, OutputDir: outputDir}))
+		var b bytes.Buffer
+		err := md.Convert([]byte("```dot render"+options+"\ndigraph { a -> b }\n```\n"), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(filepath.Join(outputDir, testImage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	if got := convert(""); strings.Contains(got, `width="100%"`) {
+		t.Fatalf("image =\n%s\nwant no width", got)
+	}
+	// The filename is the same, but the image isn't
+	if got := convert(`{"width": "100%"}`); !strings.Contains(got, `width="100%"`) {
+		t.Errorf("image =\n%s\nwant it rendered again with its width", got)
+	}
+}
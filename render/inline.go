@@ -0,0 +1,120 @@
+package render
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// Match: <svg ...>...</svg>
+	inlineSVGRegexp = regexp.MustCompile(`^<svg.*</svg>`)
+
+	xmlPrologRegexp  = regexp.MustCompile(`(?s)^\s*(<\?xml.*?\?>\s*)?(<!DOCTYPE.*?>\s*)?`)
+	xmlCommentRegexp = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// ValidateInline returns an error if inline is not a supported way of
+// inlining images.
+func ValidateInline(inline string) error {
+	switch inline {
+	case "", "none", "svg", "data-uri":
+		return nil
+	}
+	return fmt.Errorf("unsupported inline option %q, must be one of: none, svg, data-uri", inline)
+}
+
+// Inline returns how the chunk's image is embedded in the document, or an
+// empty string if the image is written to a file. Images are embedded either
+// as an <svg> element ("svg"), or as a data URI ("data-uri").
+func (r *Chunk) Inline() string {
+	inline := r.RenderOptions.Inline
+	if inline == "" {
+		inline = r.DefaultInline
+	}
+	if inline == "none" {
+		return ""
+	}
+	return inline
+}
+
+// renderInline renders the chunk's image in its primary format, and embeds it
+// in the chunk's lines.
+func (r *Chunk) renderInline() (fileName string, err error) {
+	fileName = r.FileName()
+	content, err := r.RenderContent()
+	if err != nil {
+		return "", err
+	}
+	if r.Inline() == "svg" {
+		r.setImageLine(InlineSVG(content))
+		return fileName, nil
+	}
+	if !r.HasDarkVariant() {
+		r.SetImage(DataURI(fileName, content))
+		return fileName, nil
+	}
+	darkContent, err := r.RenderDarkFormat(r.Format())
+	if err != nil {
+		return "", err
+	}
+	r.SetPicture(DataURI(fileName, content), DataURI(fileName, darkContent))
+	return fileName, nil
+}
+
+// validateInline returns an error if the chunk's image can't be inlined the
+// way it is configured to.
+func (r *Chunk) validateInline() error {
+	if r.Inline() == "svg" && r.Format() != "svg" {
+		return errors.Errorf("inline svg requires the svg format, not %s", r.Format())
+	}
+	return nil
+}
+
+// DataURI returns a data URI containing the image, with its media type
+// derived from the filename's extension.
+func DataURI(fileName string, content []byte) string {
+	return fmt.Sprintf("data:%s;base64,%s", mime.TypeByExtension(path.Ext(fileName)), base64.StdEncoding.EncodeToString(content))
+}
+
+// InlineSVG returns the SVG as an element on a single line, suitable for
+// embedding in markdown. The XML declaration, doctype and comments are
+// removed.
+func InlineSVG(svg []byte) string {
+	svg = StripXMLProlog(svg)
+	svg = xmlCommentRegexp.ReplaceAll(svg, nil)
+	var lines []string
+	for _, v := range strings.Split(string(svg), "\n") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			lines = append(lines, v)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// StripXMLProlog removes the XML declaration and doctype from an SVG, which
+// are not allowed when embedding it in HTML.
+func StripXMLProlog(svg []byte) []byte {
+	return xmlPrologRegexp.ReplaceAll(svg, nil)
+}
+
+// linkExt returns the extension of the image at link. For data URIs, the
+// extension is derived from the media type.
+func linkExt(link string) string {
+	if !strings.HasPrefix(link, "data:") {
+		return path.Ext(link)
+	}
+	mediaType := strings.SplitN(strings.TrimPrefix(link, "data:"), ";", 2)[0]
+	for _, v := range Formats {
+		if mime.TypeByExtension("."+v) == mediaType {
+			return "." + v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package render
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg"><g/></svg>`
+
+// fakeDot puts a fake dot renderer on the PATH, which outputs testSVG with an
+// XML declaration and comment.
+func fakeDot(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake renderers are shell scripts")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat >/dev/null\necho '<?xml version=\"1.0\"?>'\necho '<!-- Generated -->'\necho '" + testSVG + "'\n"
+	err := os.WriteFile(filepath.Join(dir, "dot"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestProcessInline(t *testing.T) {
+	const (
+		fileName = "render-82682d8f229ac783001529cc84b0b85b.svg"
+		code     = "```dot render\ndigraph { a -> b }\n```"
+		// The rendered SVG, with its alt text added for accessibility
+		accessibleSVG = `<svg xmlns="http://www.w3.org/2000/svg" role="img"><title>Graphviz diagram</title><g/></svg>`
+	)
+	dataURI := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte("<?xml version=\"1.0\"?>\n<!-- Generated -->\n"+accessibleSVG+"\n"))
+	tests := []struct {
+		name     string
+		doc      string
+		inline   string
+		want     string
+		wantFile bool
+		wantErr  bool
+	}{
+		{
+			name:   "svg",
+			doc:    code,
+			inline: "svg",
+			want:   region("normal", "82682d8f", accessibleSVG+"\n\n"+code),
+		},
+		{
+			name:   "data uri",
+			doc:    code,
+			inline: "data-uri",
+			want:   region("normal", "82682d8f", "![Graphviz diagram]("+dataURI+")\n\n"+code),
+		},
+		{
+			name:   "option overrides default",
+			doc:    "```dot render{\"inline\": \"svg\"}\ndigraph { a -> b }\n```",
+			inline: "data-uri",
+			want:   region("normal", "82682d8f", accessibleSVG+"\n\n```dot render{\"inline\": \"svg\"}\ndigraph { a -> b }\n```"),
+		},
+		{
+			name:   "inline changed",
+			doc:    region("normal", "82682d8f", accessibleSVG+"\n\n"+code),
+			inline: "data-uri",
+			want:   region("normal", "82682d8f", "![Graphviz diagram]("+dataURI+")\n\n"+code),
+		},
+		{
+			name:     "no longer inlined",
+			doc:      region("normal", "82682d8f", accessibleSVG+"\n\n"+code),
+			want:     region("normal", "82682d8f", "![Graphviz diagram]("+fileName+")\n\n"+code),
+			wantFile: true,
+		},
+		{
+			name:   "image above a new code block",
+			doc:    "![Photo](photo.svg)\n\n" + code,
+			inline: "svg",
+			want:   "![Photo](photo.svg)\n\n" + region("normal", "82682d8f", accessibleSVG+"\n\n"+code),
+		},
+		{
+			name:    "svg requires the svg format",
+			doc:     "```dot render{\"inline\": \"svg\", \"format\": \"png\"}\ndigraph { a -> b }\n```",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported inline option",
+			doc:     "```dot render{\"inline\": \"html\"}\ndigraph { a -> b }\n```",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDot(t)
+			outputDir := t.TempDir()
+			opts := Options{
+				Languages: []string{"dot"},
+				Inline:    tt.inline,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					return chunk.Render(outputDir, "")
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, fileName)); (err == nil) != tt.wantFile {
+				t.Errorf("image written = %v, want %v", err == nil, tt.wantFile)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			opts.RenderChunk = func(chunk *Chunk) (string, error) {
+				t.Errorf("rendered again")
+				return chunk.Render(outputDir, "")
+			}
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got {
+				t.Errorf("Process() again =\n%s\nwant it unchanged", again)
+			}
+		})
+	}
+}
+
+func TestInlineSVG(t *testing.T) {
+	tests := []struct {
+		svg  string
+		want string
+	}{
+		{testSVG, testSVG},
+		{"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE svg PUBLIC \"-//W3C//DTD SVG 1.1//EN\" \"x.dtd\">\n" + testSVG, testSVG},
+		{"<svg>\n  <!-- a\n comment -->\n  <g>\n\n  </g>\n</svg>\n", "<svg> <g> </g> </svg>"},
+	}
+	for _, tt := range tests {
+		if got := InlineSVG([]byte(tt.svg)); got != tt.want {
+			t.Errorf("InlineSVG(%q) = %q, want %q", tt.svg, got, tt.want)
+		}
+	}
+}
+
+func TestLinkExt(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"render-82682d8f229ac783001529cc84b0b85b.svg", ".svg"},
+		{"img/flow.png", ".png"},
+		{"data:image/svg+xml;base64,PHN2Zy8+", ".svg"},
+		{"data:image/png;base64,iVBO", ".png"},
+		{"data:text/plain;base64,aGk=", ""},
+	}
+	for _, tt := range tests {
+		if got := linkExt(tt.link); got != tt.want {
+			t.Errorf("linkExt(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// LinkStyle is the syntax of the images that link to rendered files, so that
+// they render natively in the static site generator or editor that displays
+// the document. Styles are pluggable: Image writes an image, and Parse reads
+// it back, so that rendered images are recognized.
+type LinkStyle struct {
+	// Image returns the image in the style's syntax.
+	Image func(image LinkedImage) string
+
+	// Parse parses an image written by Image. The width and height
+	// needn't be read back.
+	Parse func(s string) (image LinkedImage, ok bool)
+
+	// SelfClosing writes the void elements around images, such as <img>
+	// and <br>, as self-closing tags, as JSX requires.
+	SelfClosing bool
+}
+
+// LinkedImage is an image that links to a rendered file.
+type LinkedImage struct {
+	Alt    string
+	Title  string
+	Link   string
+	Width  string // Explicit width of the image, if any
+	Height string // Explicit height of the image, if any
+}
+
+// linkStyleNames are the names of the built-in link styles. Images are parsed
+// in this order, with markdown last since it is the most lenient.
+var linkStyleNames = []string{"hugo", "mkdocs", "docusaurus", "obsidian", "markdown"}
+
+// LinkStyles are the built-in link styles, by name.
+var LinkStyles = map[string]LinkStyle{
+	"markdown": {
+		Image: func(image LinkedImage) string {
+			return buildMarkdownImage(image.Alt, image.Title, image.Link)
+		},
+		Parse: parseMarkdownImage,
+	},
+	// Hugo's figure shortcode
+	"hugo": {
+		Image: func(image LinkedImage) string {
+			params := fmt.Sprintf(`src="%s" alt="%s"`, markdownTitleEscaper.Replace(image.Link), markdownTitleEscaper.Replace(image.Alt))
+			for _, v := range [][2]string{{"title", image.Title}, {"width", image.Width}, {"height", image.Height}} {
+				if v[1] != "" {
+					params += fmt.Sprintf(` %s="%s"`, v[0], markdownTitleEscaper.Replace(v[1]))
+				}
+			}
+			return fmt.Sprintf("{{< figure %s >}}", params)
+		},
+		Parse: func(s string) (image LinkedImage, ok bool) {
+			matches := hugoFigureRegexp.FindStringSubmatch(s)
+			if len(matches) != 4 {
+				return image, false
+			}
+			return LinkedImage{Link: unescapeMarkdown(matches[1]), Alt: unescapeMarkdown(matches[2]), Title: unescapeMarkdown(matches[3])}, true
+		},
+	},
+	// Markdown images with the attribute lists of MkDocs Material
+	"mkdocs": {
+		Image: func(image LinkedImage) string {
+			var attrs []string
+			if image.Width != "" {
+				attrs = append(attrs, fmt.Sprintf(`width="%s"`, image.Width))
+			}
+			if image.Height != "" {
+				attrs = append(attrs, fmt.Sprintf(`height="%s"`, image.Height))
+			}
+			attrs = append(attrs, "loading=lazy")
+			return fmt.Sprintf("%s{ %s }", buildMarkdownImage(image.Alt, image.Title, image.Link), strings.Join(attrs, " "))
+		},
+		Parse: func(s string) (image LinkedImage, ok bool) {
+			matches := mkdocsImageRegexp.FindStringSubmatch(s)
+			if matches == nil {
+				return image, false
+			}
+			return parseMarkdownImage(matches[1])
+		},
+	},
+	// JSX <img> elements in Docusaurus MDX, which bundle relative images
+	// with require
+	"docusaurus": {
+		Image: func(image LinkedImage) string {
+			src := fmt.Sprintf(`"%s"`, html.EscapeString(image.Link))
+			if isRelativeLink(image.Link) {
+				link := image.Link
+				if !strings.HasPrefix(link, "./") && !strings.HasPrefix(link, "../") {
+					link = "./" + link
+				}
+				src = fmt.Sprintf(`{require("%s").default}`, markdownTitleEscaper.Replace(link))
+			}
+			attrs := fmt.Sprintf(`src=%s alt="%s"`, src, html.EscapeString(image.Alt))
+			for _, v := range [][2]string{{"title", image.Title}, {"width", image.Width}, {"height", image.Height}} {
+				if v[1] != "" {
+					attrs += fmt.Sprintf(` %s="%s"`, v[0], html.EscapeString(v[1]))
+				}
+			}
+			return fmt.Sprintf("<img %s />", attrs)
+		},
+		Parse: func(s string) (image LinkedImage, ok bool) {
+			matches := docusaurusImageRegexp.FindStringSubmatch(s)
+			if len(matches) != 5 {
+				return image, false
+			}
+			link := html.UnescapeString(matches[2])
+			if matches[1] != "" {
+				link = strings.TrimPrefix(unescapeMarkdown(matches[1]), "./")
+			}
+			return LinkedImage{Link: link, Alt: html.UnescapeString(matches[3]), Title: html.UnescapeString(matches[4])}, true
+		},
+		SelfClosing: true,
+	},
+	// Obsidian's embeds. Embeds can't hold titles, and only link to files
+	// in the vault, so other images are written as markdown images.
+	"obsidian": {
+		Image: func(image LinkedImage) string {
+			if !isRelativeLink(image.Link) {
+				return buildMarkdownImage(image.Alt, image.Title, image.Link)
+			}
+			if image.Alt == "" {
+				return fmt.Sprintf("![[%s]]", image.Link)
+			}
+			return fmt.Sprintf("![[%s|%s]]", image.Link, obsidianAltEscaper.Replace(image.Alt))
+		},
+		Parse: func(s string) (image LinkedImage, ok bool) {
+			matches := obsidianEmbedRegexp.FindStringSubmatch(s)
+			if len(matches) != 3 {
+				return parseMarkdownImage(s)
+			}
+			return LinkedImage{Link: matches[1], Alt: matches[2]}, true
+		},
+	},
+}
+
+var (
+	// Match: {{< figure src="image.svg" alt="Alt text" title="Title" width="600" >}}
+	// Capture groups on the link, the alt text and the title.
+	hugoFigureRegexp = regexp.MustCompile(`^\{\{< figure src="((?:[^"\\]|\\.)*)" alt="((?:[^"\\]|\\.)*)"(?: title="((?:[^"\\]|\\.)*)")?(?: width="(?:[^"\\]|\\.)*")?(?: height="(?:[^"\\]|\\.)*")? >\}\}$`)
+
+	// Match: ![Alt text](image.svg){ width="600" loading=lazy }
+	// Capture group on the markdown image, followed by the groups of
+	// markdownImageRegexp.
+	mkdocsImageRegexp = regexp.MustCompile(`^(` + markdownImageRegexp.String() + `)\{[^}]*\}$`)
+
+	// Match: <img src={require("./image.svg").default} alt="Alt text" title="Title" />
+	// Capture groups on the required link, the link, the alt text and the title.
+	docusaurusImageRegexp = regexp.MustCompile(`^<img src=(?:\{require\("((?:[^"\\]|\\.)*)"\)\.default\}|"([^"]*)") alt="([^"]*)"(?: title="([^"]*)")?(?: width="[^"]*")?(?: height="[^"]*")?(?: /)?>$`)
+
+	// Match: ![[image.svg|Alt text]]
+	// Capture groups on the link and the alt text.
+	obsidianEmbedRegexp = regexp.MustCompile(`^!\[\[([^|\]]+)(?:\|([^\]]*))?\]\]$`)
+
+	obsidianAltEscaper = strings.NewReplacer("|", "-", "[", "(", "]", ")")
+
+	// Match: <br>, <img ...>, <source ...>, optionally self-closed
+	// Capture groups on the element name and its attributes.
+	voidElementRegexp = regexp.MustCompile(`<(br|img|source)\b([^>]*?)\s*/?>`)
+)
+
+// ParseLinkStyle returns the built-in link style with the name. An empty name
+// is the markdown style.
+func ParseLinkStyle(name string) (LinkStyle, error) {
+	if name == "" {
+		name = "markdown"
+	}
+	style, ok := LinkStyles[name]
+	if !ok {
+		return LinkStyle{}, fmt.Errorf("unsupported link style %q, must be one of: markdown, hugo, mkdocs, docusaurus, obsidian", name)
+	}
+	return style, nil
+}
+
+func (r *Chunk) linkStyle() LinkStyle {
+	if r.LinkStyle.Image == nil || r.LinkStyle.Parse == nil {
+		return LinkStyles["markdown"]
+	}
+	return r.LinkStyle
+}
+
+// buildImage returns the image linking to link, in the chunk's link style.
+// Explicit dimensions of SVGs are passed on to the style.
+func (r *Chunk) buildImage(alt string, title string, link string) string {
+	image := LinkedImage{Alt: alt, Title: title, Link: link}
+	if r.Format() == "svg" {
+		opts := r.svgOptions()
+		image.Width, image.Height = opts.Width, opts.Height
+	}
+	return r.linkStyle().Image(image)
+}
+
+// readBack returns the alt text and the title as they are read back from an
+// image linking to link in the chunk's link style, since not every style can
+// hold both.
+func (r *Chunk) readBack(alt string, title string, link string) (string, string) {
+	style := r.linkStyle()
+	image, ok := parseLinkedImage(style.Image(LinkedImage{Alt: alt, Title: title, Link: link}), style)
+	if !ok {
+		return alt, title
+	}
+	return image.Alt, image.Title
+}
+
+// parseLinkedImage parses an image in the link style, or in any of the
+// built-in link styles, so that images are recognized after the link style
+// changes.
+func parseLinkedImage(s string, style LinkStyle) (image LinkedImage, ok bool) {
+	if style.Parse != nil {
+		if image, ok := style.Parse(s); ok {
+			return image, true
+		}
+	}
+	for _, name := range linkStyleNames {
+		if image, ok := LinkStyles[name].Parse(s); ok {
+			return image, true
+		}
+	}
+	return image, false
+}
+
+func parseMarkdownImage(s string) (image LinkedImage, ok bool) {
+	matches := markdownImageRegexp.FindStringSubmatch(s)
+	if len(matches) != 4 {
+		return image, false
+	}
+	return LinkedImage{Alt: unescapeMarkdown(matches[1]), Link: matches[2], Title: unescapeMarkdown(matches[3])}, true
+}
+
+// isRelativeLink returns whether the link is relative to the document, rather
+// than absolute, a URL or a data URI.
+func isRelativeLink(link string) bool {
+	return !strings.HasPrefix(link, "/") && !strings.Contains(link, ":")
+}
+
+// selfCloseVoidElements writes <br>, <img> and <source> elements as
+// self-closing tags.
+func selfCloseVoidElements(s string) string {
+	return voidElementRegexp.ReplaceAllString(s, "<$1$2 />")
+}
+
+// openVoidElements writes self-closing <br>, <img> and <source> elements as
+// HTML void elements, so that they are parsed alike.
+func openVoidElements(s string) string {
+	return voidElementRegexp.ReplaceAllString(s, "<$1$2>")
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLinkStyleRoundTrip(t *testing.T) {
+	images := []struct {
+		name  string
+		image LinkedImage
+	}{
+		{"alt", LinkedImage{Alt: "Graphviz diagram", Link: "render-82682d8f.svg"}},
+		{"empty alt", LinkedImage{Link: "render-82682d8f.svg"}},
+		{"title", LinkedImage{Alt: "Graphviz diagram", Title: "Auth flow", Link: "assets/render-82682d8f.svg"}},
+		{"escaped", LinkedImage{Alt: `a [b] "c" \ d|e & <f>`, Title: `say "hi" \ & <bye>`, Link: "render-82682d8f.svg"}},
+		{"dimensions", LinkedImage{Alt: "Graphviz diagram", Link: "render-82682d8f.svg", Width: "100%", Height: "200"}},
+		{"parent directory", LinkedImage{Alt: "Graphviz diagram", Link: "../assets/render-82682d8f.svg"}},
+		{"absolute", LinkedImage{Alt: "Graphviz diagram", Title: "Auth flow", Link: "/diagrams/render-82682d8f.svg"}},
+		{"url", LinkedImage{Alt: "Graphviz diagram", Title: "Auth flow", Link: "https://example.com/render-82682d8f.svg"}},
+	}
+	for _, name := range linkStyleNames {
+		style := LinkStyles[name]
+		for _, tt := range images {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				// Dimensions aren't read back, and Obsidian's
+				// embeds can't hold titles or some characters
+				want := tt.image
+				want.Width, want.Height = "", ""
+				if name == "obsidian" && isRelativeLink(want.Link) {
+					want.Alt = obsidianAltEscaper.Replace(want.Alt)
+					want.Title = ""
+				}
+
+				s := style.Image(tt.image)
+				got, ok := style.Parse(s)
+				if !ok {
+					t.Fatalf("Parse(%s) failed", s)
+				}
+				if got != want {
+					t.Errorf("Parse(%s) = %+v, want %+v", s, got, want)
+				}
+
+				// Images are recognized after the link style
+				// changes
+				got, ok = parseLinkedImage(s, LinkStyles["markdown"])
+				if !ok || got != want {
+					t.Errorf("parseLinkedImage(%s) = %+v, %v, want %+v", s, got, ok, want)
+				}
+			})
+		}
+	}
+}
+
+func TestProcessLinkStyles(t *testing.T) {
+	fences := []string{
+		"```dot render",
+		"```dot render" + `{"title": "Auth \"flow\""}`,
+		"```dot render" + `{"caption": "Auth flow", "id": "auth"}`,
+		"```dot render" + `{"mode": "side-by-side", "alt": "A [flow]"}`,
+	}
+	for _, name := range linkStyleNames {
+		for _, fence := range fences {
+			t.Run(name+"/"+fence, func(t *testing.T) {
+				doc := strings.Join([]string{"# Title", "", fence, testCode, "```", ""}, "\n")
+				opts := Options{
+					Languages: []string{"dot"},
+					LinkStyle: LinkStyles[name],
+					RenderChunk: func(chunk *Chunk) (string, error) {
+						chunk.SetImage("assets/" + chunk.FileName())
+						return chunk.FileName(), nil
+					},
+				}
+				rendered, err := Process(doc, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The rendered image is read back, so that
+				// the code block isn't rendered again
+				opts.RenderChunk = func(chunk *Chunk) (string, error) {
+					t.Fatalf("code block was rendered again:\n%s", rendered)
+					return "", nil
+				}
+				output, err := Process(rendered, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if output != rendered {
+					t.Errorf("Process() =\n%s\nwant\n%s", output, rendered)
+				}
+			})
+		}
+	}
+}
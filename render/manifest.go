@@ -0,0 +1,115 @@
+package render
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestFilename is the name of the manifest file kept in each output
+// directory. It records the images generated there, so that they can be
+// identified without relying on the shape of their filenames.
+const ManifestFilename = ".md-code-renderer.json"
+
+// Manifest lists the images generated in an output directory. Images with
+// explicitly set filenames are not recorded.
+type Manifest struct {
+	Files []string          `json:"files"`
+	Keys  map[string]string `json:"keys,omitempty"` // Render keys of the images, see Chunk.RenderKey
+}
+
+// ReadManifest reads the manifest in dir. An empty manifest is returned if
+// it doesn't exist.
+func ReadManifest(dir string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(path.Join(dir, ManifestFilename))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, errors.Wrap(err, "read manifest")
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return m, errors.Wrap(err, "unmarshal manifest")
+	}
+	return m, nil
+}
+
+// Write writes the manifest to dir. The manifest is removed if it is empty.
+func (m Manifest) Write(dir string) error {
+	manifestPath := path.Join(dir, ManifestFilename)
+	if len(m.Files) == 0 {
+		err := os.Remove(manifestPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove manifest")
+		}
+		return nil
+	}
+	sort.Strings(m.Files)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest")
+	}
+	return errors.Wrap(os.WriteFile(manifestPath, append(b, '\n'), 0644), "write manifest")
+}
+
+// Contains returns whether fileName is recorded in the manifest.
+func (m Manifest) Contains(fileName string) bool {
+	for _, v := range m.Files {
+		if v == fileName {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes fileName from the manifest.
+func (m *Manifest) Remove(fileName string) {
+	var files []string
+	for _, v := range m.Files {
+		if v != fileName {
+			files = append(files, v)
+		}
+	}
+	m.Files = files
+	delete(m.Keys, fileName)
+}
+
+// recordGeneratedImage adds fileName to the manifest in dir, with the render
+// key it was rendered with, if any.
+func recordGeneratedImage(dir string, fileName string, key string) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	if m.Contains(fileName) && m.Keys[fileName] == key {
+		return nil
+	}
+	if !m.Contains(fileName) {
+		m.Files = append(m.Files, fileName)
+	}
+	if key != "" {
+		if m.Keys == nil {
+			m.Keys = make(map[string]string)
+		}
+		m.Keys[fileName] = key
+	}
+	return m.Write(dir)
+}
+
+// isRecordedImage returns whether the image at link, relative to the document,
+// is recorded in the manifest of its directory.
+func isRecordedImage(documentName string, link string) bool {
+	if link == "" || path.IsAbs(link) || strings.Contains(link, ":") {
+		return false
+	}
+	dir := path.Join(path.Dir(filepath.ToSlash(documentName)), path.Dir(link))
+	m, err := ReadManifest(dir)
+	return err == nil && m.Contains(path.Base(link))
+}
@@ -0,0 +1,319 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultDPI is the resolution renderers use for raster images by default.
+	defaultDPI = 96
+
+	jpegQuality = 90
+)
+
+// RasterOptions configures the raster images (png, jpeg, webp) rendered from
+// code blocks. Options are applied with the renderer's own flags where
+// possible, and to PNGs after rendering otherwise.
+type RasterOptions struct {
+	Scale      float64 // Scale factor, relative to the resolution
+	DPI        int     // Resolution. If zero, the renderer's default of 96 is used.
+	Padding    int     // Padding around the image, in pixels
+	Background string  // Background color, e.g. "#ffffff" or "white". If empty, the background is transparent.
+	Compress   bool    // Recompress PNGs losslessly at the best compression level
+}
+
+func (o RasterOptions) isZero() bool {
+	return o == RasterOptions{}
+}
+
+// Validate returns an error if the options are invalid.
+func (o RasterOptions) Validate() error {
+	if o.Scale < 0 {
+		return errors.New("scale must not be negative")
+	}
+	if o.DPI < 0 {
+		return errors.New("dpi must not be negative")
+	}
+	if o.Padding < 0 {
+		return errors.New("padding must not be negative")
+	}
+	if o.Background != "" {
+		_, err := parseColor(o.Background)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dpi returns the resolution to render at, with the scale applied.
+func (o RasterOptions) dpi() float64 {
+	dpi := float64(o.DPI)
+	if dpi == 0 {
+		dpi = defaultDPI
+	}
+	if o.Scale != 0 {
+		dpi *= o.Scale
+	}
+	return dpi
+}
+
+func isRasterFormat(format string) bool {
+	return format == "png" || format == "jpeg" || format == "webp"
+}
+
+// rasterOptions returns the raster options for the chunk, with the chunk's
+// options taking precedence. Dark variants keep their transparent background,
+// as a background meant for the light variant would hide the dark theme.
+func (r *Chunk) rasterOptions(dark bool) RasterOptions {
+	opts := r.RasterOptions
+	if r.RenderOptions.Scale != 0 {
+		opts.Scale = r.RenderOptions.Scale
+	}
+	if r.RenderOptions.DPI != 0 {
+		opts.DPI = r.RenderOptions.DPI
+	}
+	if r.RenderOptions.Padding != 0 {
+		opts.Padding = r.RenderOptions.Padding
+	}
+	if r.RenderOptions.Background != "" {
+		opts.Background = r.RenderOptions.Background
+	}
+	if dark {
+		opts.Background = ""
+	}
+	return opts
+}
+
+// rasterArgs returns the arguments that apply opts with the language's
+// renderer, along with the options that the renderer doesn't support, which
+// are to be applied after rendering. Options must be valid.
+func rasterArgs(language string, opts RasterOptions) (args []string, remaining RasterOptions) {
+	remaining = opts
+	var background color.NRGBA
+	if opts.Background != "" {
+		background, _ = parseColor(opts.Background)
+	}
+	switch language {
+	case "dot":
+		if opts.Scale != 0 || opts.DPI != 0 {
+			args = append(args, "-Gdpi="+formatFloat(opts.dpi()))
+		}
+		if opts.Background != "" {
+			args = append(args, "-Gbgcolor="+colorHex(background))
+		}
+		if opts.Padding != 0 {
+			// Padding is set in inches
+			args = append(args, "-Gpad="+formatFloat(float64(opts.Padding)/opts.dpi()))
+		}
+		remaining = RasterOptions{Compress: opts.Compress}
+	case "plantuml":
+		if opts.Scale != 0 || opts.DPI != 0 {
+			args = append(args, "-Sdpi="+strconv.Itoa(int(math.Round(opts.dpi()))))
+			remaining.Scale, remaining.DPI = 0, 0
+		}
+		// Only opaque colors are supported. The background is still
+		// needed to fill the padding, if any.
+		if opts.Background != "" && background.A == 0xff {
+			args = append(args, "-SbackgroundColor="+colorHex(background))
+			if opts.Padding == 0 {
+				remaining.Background = ""
+			}
+		}
+	}
+	return args, remaining
+}
+
+// ProcessPNG applies the raster options to a PNG.
+func ProcessPNG(content []byte, opts RasterOptions) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode png")
+	}
+	img := image.NewRGBA(src.Bounds().Sub(src.Bounds().Min))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	if factor := opts.dpi() / defaultDPI; factor != 1 {
+		img = resizeBilinear(img, int(math.Round(float64(img.Bounds().Dx())*factor)), int(math.Round(float64(img.Bounds().Dy())*factor)))
+	}
+	return encodeRaster(img, "png", opts)
+}
+
+// encodeRaster pads the image and fills its background according to opts,
+// and encodes it in format. JPEGs have no transparency, so they are given a
+// white background if there is none.
+func encodeRaster(img *image.RGBA, format string, opts RasterOptions) ([]byte, error) {
+	background := color.NRGBA{}
+	if opts.Background != "" {
+		var err error
+		background, err = parseColor(opts.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if format == "jpeg" && background.A != 0xff {
+		background = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	}
+	if opts.Padding != 0 || background.A != 0 {
+		padded := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx()+2*opts.Padding, img.Bounds().Dy()+2*opts.Padding))
+		draw.Draw(padded, padded.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		draw.Draw(padded, img.Bounds().Add(image.Pt(opts.Padding, opts.Padding)), img, image.Point{}, draw.Over)
+		img = padded
+	}
+
+	var b bytes.Buffer
+	switch format {
+	case "png":
+		var out image.Image = img
+		encoder := png.Encoder{}
+		if opts.Compress {
+			encoder.CompressionLevel = png.BestCompression
+			if paletted, ok := toPaletted(img); ok {
+				out = paletted
+			}
+		}
+		err := encoder.Encode(&b, out)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode png")
+		}
+	case "jpeg":
+		err := jpeg.Encode(&b, img, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return nil, errors.Wrap(err, "encode jpeg")
+		}
+	case "webp":
+		err := encodeWebP(&b, img)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode webp")
+		}
+	default:
+		return nil, errors.Errorf("unsupported raster format: %s", format)
+	}
+	return b.Bytes(), nil
+}
+
+// resizeBilinear scales the image to the given size with bilinear
+// interpolation. Pixels are interpolated premultiplied, to avoid dark fringes
+// around transparent areas.
+func resizeBilinear(src *image.RGBA, width int, height int) *image.RGBA {
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	scaleX := float64(srcWidth) / float64(width)
+	scaleY := float64(srcHeight) / float64(height)
+	for y := 0; y < height; y++ {
+		sy := math.Max(0, (float64(y)+0.5)*scaleY-0.5)
+		y0 := int(sy)
+		y1 := minInt(y0+1, srcHeight-1)
+		fy := sy - float64(y0)
+		for x := 0; x < width; x++ {
+			sx := math.Max(0, (float64(x)+0.5)*scaleX-0.5)
+			x0 := int(sx)
+			x1 := minInt(x0+1, srcWidth-1)
+			fx := sx - float64(x0)
+
+			i00, i10 := src.PixOffset(x0, y0), src.PixOffset(x1, y0)
+			i01, i11 := src.PixOffset(x0, y1), src.PixOffset(x1, y1)
+			d := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := float64(src.Pix[i00+c])*(1-fx) + float64(src.Pix[i10+c])*fx
+				bottom := float64(src.Pix[i01+c])*(1-fx) + float64(src.Pix[i11+c])*fx
+				dst.Pix[d+c] = uint8(math.Round(top*(1-fy) + bottom*fy))
+			}
+		}
+	}
+	return dst
+}
+
+// toPaletted converts the image to a paletted image, if it has at most 256
+// colors, so that it is encoded losslessly as an indexed PNG.
+func toPaletted(img *image.RGBA) (*image.Paletted, bool) {
+	indexes := make(map[color.RGBA]uint8)
+	var palette color.Palette
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		if _, ok := indexes[c]; ok {
+			continue
+		}
+		if len(palette) == 256 {
+			return nil, false
+		}
+		indexes[c] = uint8(len(palette))
+		palette = append(palette, c)
+	}
+	paletted := image.NewPaletted(img.Bounds(), palette)
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			paletted.SetColorIndex(x, y, indexes[img.RGBAAt(x, y)])
+		}
+	}
+	return paletted, true
+}
+
+var namedColors = map[string]color.NRGBA{
+	"white":       {0xff, 0xff, 0xff, 0xff},
+	"black":       {0x00, 0x00, 0x00, 0xff},
+	"transparent": {0x00, 0x00, 0x00, 0x00},
+}
+
+// parseColor parses a color in the forms #rgb, #rrggbb and #rrggbbaa, or one
+// of the names white, black and transparent.
+func parseColor(s string) (color.NRGBA, error) {
+	if c, ok := namedColors[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if !strings.HasPrefix(s, "#") || len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q, must be of the form #rrggbb, or one of: white, black, transparent", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// colorHex formats the color as #rrggbb, or #rrggbbaa if it isn't opaque.
+func colorHex(c color.NRGBA) string {
+	if c.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
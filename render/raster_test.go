@@ -0,0 +1,155 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{s: "#ffffff", want: color.NRGBA{0xff, 0xff, 0xff, 0xff}},
+		{s: "#1a2B3c", want: color.NRGBA{0x1a, 0x2b, 0x3c, 0xff}},
+		{s: "#abc", want: color.NRGBA{0xaa, 0xbb, 0xcc, 0xff}},
+		{s: "#11223380", want: color.NRGBA{0x11, 0x22, 0x33, 0x80}},
+		{s: "white", want: color.NRGBA{0xff, 0xff, 0xff, 0xff}},
+		{s: "Black", want: color.NRGBA{0x00, 0x00, 0x00, 0xff}},
+		{s: "transparent", want: color.NRGBA{}},
+		{s: "ffffff", wantErr: true},
+		{s: "#ff", wantErr: true},
+		{s: "#fffff", wantErr: true},
+		{s: "#fffffffff", wantErr: true},
+		{s: "#gggggg", wantErr: true},
+		{s: "#-12345", wantErr: true},
+		{s: "red", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseColor(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseColor(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseColor(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestColorHex(t *testing.T) {
+	tests := []struct {
+		c    color.NRGBA
+		want string
+	}{
+		{color.NRGBA{0x1a, 0x2b, 0x3c, 0xff}, "#1a2b3c"},
+		{color.NRGBA{0x1a, 0x2b, 0x3c, 0x80}, "#1a2b3c80"},
+		{color.NRGBA{}, "#00000000"},
+	}
+	for _, tt := range tests {
+		if got := colorHex(tt.c); got != tt.want {
+			t.Errorf("colorHex(%v) = %s, want %s", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestResizeBilinear(t *testing.T) {
+	opaque := color.RGBA{0x20, 0x40, 0x60, 0xff}
+	tests := []struct {
+		name     string
+		srcSize  image.Point
+		pixels   []color.RGBA // Pixels of the source image, row by row
+		size     image.Point  // Requested size
+		wantSize image.Point
+		want     map[image.Point]color.RGBA
+	}{
+		{
+			name:     "uniform upscale",
+			srcSize:  image.Pt(2, 2),
+			pixels:   []color.RGBA{opaque, opaque, opaque, opaque},
+			size:     image.Pt(5, 3),
+			wantSize: image.Pt(5, 3),
+			want:     map[image.Point]color.RGBA{{0, 0}: opaque, {4, 2}: opaque, {2, 1}: opaque},
+		},
+		{
+			name:     "downscale averages",
+			srcSize:  image.Pt(2, 1),
+			pixels:   []color.RGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0xff}},
+			size:     image.Pt(1, 1),
+			wantSize: image.Pt(1, 1),
+			want:     map[image.Point]color.RGBA{{0, 0}: {0x80, 0x80, 0x80, 0xff}},
+		},
+		{
+			name:     "premultiplied edges",
+			srcSize:  image.Pt(2, 1),
+			pixels:   []color.RGBA{{0xff, 0, 0, 0xff}, {}},
+			size:     image.Pt(1, 1),
+			wantSize: image.Pt(1, 1),
+			want:     map[image.Point]color.RGBA{{0, 0}: {0x80, 0, 0, 0x80}},
+		},
+		{
+			name:     "zero size",
+			srcSize:  image.Pt(2, 1),
+			pixels:   []color.RGBA{opaque, opaque},
+			size:     image.Pt(0, 0),
+			wantSize: image.Pt(1, 1),
+			want:     map[image.Point]color.RGBA{{0, 0}: opaque},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rectangle{Max: tt.srcSize})
+			for i, v := range tt.pixels {
+				src.SetRGBA(i%tt.srcSize.X, i/tt.srcSize.X, v)
+			}
+			dst := resizeBilinear(src, tt.size.X, tt.size.Y)
+			if dst.Bounds().Size() != tt.wantSize {
+				t.Fatalf("size = %v, want %v", dst.Bounds().Size(), tt.wantSize)
+			}
+			for p, want := range tt.want {
+				if got := dst.RGBAAt(p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestToPaletted(t *testing.T) {
+	tests := []struct {
+		name   string
+		colors int
+		wantOK bool
+	}{
+		{"one color", 1, true},
+		{"256 colors", 256, true},
+		{"257 colors", 257, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 300, 1))
+			for x := 0; x < 300; x++ {
+				i := x % tt.colors
+				img.SetRGBA(x, 0, color.RGBA{uint8(i), uint8(i >> 8), 0, 0xff})
+			}
+			paletted, ok := toPaletted(img)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if len(paletted.Palette) != tt.colors {
+				t.Errorf("palette has %d colors, want %d", len(paletted.Palette), tt.colors)
+			}
+			for x := 0; x < 300; x++ {
+				if got, want := color.RGBAModel.Convert(paletted.At(x, 0)), img.RGBAAt(x, 0); got != want {
+					t.Fatalf("pixel %d = %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// rasterizedFormats are the formats that are rasterized from the renderer's
+// SVG, if the renderer doesn't support them itself.
+var rasterizedFormats = []string{"png", "jpeg", "webp"}
+
+// Default font size of SVG text, in user units
+const defaultSVGFontSize = 16
+
+var (
+	// Match: translate(1 2), scale(2), rotate(90), matrix(1,0,0,1,0,0)
+	// Capture groups on the function and its arguments.
+	svgTransformRegexp = regexp.MustCompile(`(\w+)\s*\(([^)]*)\)`)
+
+	// Match: rgb(0,0,0)
+	// Capture groups on the components.
+	svgRGBColorRegexp = regexp.MustCompile(`^rgb\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*\)$`)
+)
+
+// RasterizeSVG converts an SVG to an image in format (png, jpeg or webp), at the
+// resolution given by opts. Shapes are drawn by oksvg, which doesn't support
+// text, so text is drawn separately with the Go fonts.
+func RasterizeSVG(svg []byte, format string, opts RasterOptions) ([]byte, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svg), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse svg")
+	}
+	width, height := svgSize(svg, icon)
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("svg has no size")
+	}
+	factor := opts.dpi() / defaultDPI
+	w := int(math.Ceil(width * factor))
+	h := int(math.Ceil(height * factor))
+
+	// Map the viewBox onto the image
+	icon.Transform = rasterx.Identity.
+		Scale(float64(w)/icon.ViewBox.W, float64(h)/icon.ViewBox.H).
+		Translate(-icon.ViewBox.X, -icon.ViewBox.Y)
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	err = drawSVGText(img, svg, icon.Transform)
+	if err != nil {
+		return nil, err
+	}
+	return encodeRaster(img, format, opts)
+}
+
+// svgSize returns the size of the SVG in pixels, from its width and height,
+// or its viewBox if they are missing or relative.
+func svgSize(svg []byte, icon *oksvg.SvgIcon) (width float64, height float64) {
+	width, height = icon.ViewBox.W, icon.ViewBox.H
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Entity = xml.HTMLEntity
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return width, height
+		}
+		t, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if i := findAttr(t.Attr, "width"); i >= 0 {
+			if v, ok := svgLengthPixels(t.Attr[i].Value); ok {
+				width = v
+			}
+		}
+		if i := findAttr(t.Attr, "height"); i >= 0 {
+			if v, ok := svgLengthPixels(t.Attr[i].Value); ok {
+				height = v
+			}
+		}
+		return width, height
+	}
+}
+
+// svgLengthPixels converts an absolute length to pixels.
+func svgLengthPixels(length string) (float64, bool) {
+	matches := svgLengthRegexp.FindStringSubmatch(length)
+	if len(matches) != 3 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if matches[2] == "pt" {
+		v = v * 96 / 72
+	}
+	return v, true
+}
+
+// svgTextStyle is the style of text, inherited from enclosing elements.
+type svgTextStyle struct {
+	transform  rasterx.Matrix2D
+	fill       string
+	fontSize   float64
+	fontFamily string
+	fontWeight string
+	anchor     string
+	baseline   string
+}
+
+// svgText is a text element to be drawn.
+type svgText struct {
+	style svgTextStyle
+	x, y  float64
+	text  strings.Builder
+}
+
+// drawSVGText draws the SVG's text elements onto img, with transform mapping
+// the SVG's user space onto the image.
+func drawSVGText(img *image.RGBA, svg []byte, transform rasterx.Matrix2D) error {
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Entity = xml.HTMLEntity
+	styles := []svgTextStyle{{transform: transform, fill: "black", fontSize: defaultSVGFontSize}}
+	var text *svgText
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "parse svg")
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			style := styles[len(styles)-1].apply(t.Attr)
+			styles = append(styles, style)
+			if t.Name.Local == "text" {
+				text = &svgText{style: style}
+				text.x, _ = parseSVGLength(attrValue(t.Attr, "x"))
+				text.y, _ = parseSVGLength(attrValue(t.Attr, "y"))
+			}
+		case xml.EndElement:
+			if len(styles) > 1 {
+				styles = styles[:len(styles)-1]
+			}
+			if t.Name.Local == "text" && text != nil {
+				err = text.draw(img)
+				if err != nil {
+					return err
+				}
+				text = nil
+			}
+		case xml.CharData:
+			if text != nil {
+				text.text.Write(t)
+			}
+		}
+	}
+}
+
+// apply returns the style with the element's attributes applied.
+func (s svgTextStyle) apply(attrs []xml.Attr) svgTextStyle {
+	properties := make(map[string]string)
+	for _, v := range attrs {
+		if v.Name.Space == "" {
+			properties[v.Name.Local] = v.Value
+		}
+	}
+	// Properties in the style attribute take precedence
+	for _, v := range strings.Split(properties["style"], ";") {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) == 2 {
+			properties[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if v, ok := properties["transform"]; ok {
+		s.transform = applySVGTransform(s.transform, v)
+	}
+	if v, ok := properties["fill"]; ok {
+		s.fill = v
+	}
+	if v, ok := properties["font-size"]; ok {
+		if size, ok := parseSVGLength(v); ok {
+			s.fontSize = size
+		}
+	}
+	if v, ok := properties["font-family"]; ok {
+		s.fontFamily = v
+	}
+	if v, ok := properties["font-weight"]; ok {
+		s.fontWeight = v
+	}
+	if v, ok := properties["text-anchor"]; ok {
+		s.anchor = v
+	}
+	if v, ok := properties["dominant-baseline"]; ok {
+		s.baseline = v
+	}
+	return s
+}
+
+func (t *svgText) draw(img *image.RGBA) error {
+	text := strings.TrimSpace(t.text.String())
+	fill, ok := parseSVGColor(t.style.fill)
+	if text == "" || !ok {
+		return nil
+	}
+	// Text is drawn unrotated, scaled by the transform's average scale
+	m := t.style.transform
+	scale := math.Sqrt(math.Abs(m.A*m.D - m.B*m.C))
+	face, err := svgFontFace(t.style.fontFamily, t.style.fontWeight, t.style.fontSize*scale)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	x, y := m.Transform(t.x, t.y)
+	drawer := font.Drawer{Dst: img, Src: image.NewUniform(fill), Face: face}
+	switch t.style.anchor {
+	case "middle":
+		x -= float64(drawer.MeasureString(text)) / 64 / 2
+	case "end":
+		x -= float64(drawer.MeasureString(text)) / 64
+	}
+	metrics := face.Metrics()
+	switch t.style.baseline {
+	case "central", "middle":
+		y += float64(metrics.Ascent-metrics.Descent) / 64 / 2
+	case "hanging", "text-before-edge":
+		y += float64(metrics.Ascent) / 64
+	}
+	drawer.Dot = fixed.Point26_6{X: fixed.Int26_6(x * 64), Y: fixed.Int26_6(y * 64)}
+	drawer.DrawString(text)
+	return nil
+}
+
+// applySVGTransform applies the transform functions in an SVG transform
+// attribute to m. Unknown functions are ignored.
+func applySVGTransform(m rasterx.Matrix2D, transform string) rasterx.Matrix2D {
+	for _, v := range svgTransformRegexp.FindAllStringSubmatch(transform, -1) {
+		var args []float64
+		for _, arg := range strings.FieldsFunc(v[2], func(r rune) bool { return r == ',' || r == ' ' }) {
+			f, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return m
+			}
+			args = append(args, f)
+		}
+		switch {
+		case v[1] == "translate" && len(args) == 1:
+			m = m.Translate(args[0], 0)
+		case v[1] == "translate" && len(args) == 2:
+			m = m.Translate(args[0], args[1])
+		case v[1] == "scale" && len(args) == 1:
+			m = m.Scale(args[0], args[0])
+		case v[1] == "scale" && len(args) == 2:
+			m = m.Scale(args[0], args[1])
+		case v[1] == "rotate" && len(args) == 1:
+			m = m.Rotate(args[0] * math.Pi / 180)
+		case v[1] == "matrix" && len(args) == 6:
+			m = m.Mult(rasterx.Matrix2D{A: args[0], B: args[1], C: args[2], D: args[3], E: args[4], F: args[5]})
+		}
+	}
+	return m
+}
+
+// parseSVGColor parses a fill color. Returns false for "none" and unknown
+// colors.
+func parseSVGColor(s string) (color.Color, bool) {
+	s = strings.TrimSpace(s)
+	if c, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return c, true
+	}
+	if matches := svgRGBColorRegexp.FindStringSubmatch(s); len(matches) == 4 {
+		var rgb [3]uint8
+		for i := range rgb {
+			v, _ := strconv.Atoi(matches[i+1])
+			rgb[i] = uint8(minInt(v, 0xff))
+		}
+		return color.NRGBA{rgb[0], rgb[1], rgb[2], 0xff}, true
+	}
+	if strings.HasPrefix(s, "#") {
+		c, err := parseColor(s)
+		return c, err == nil
+	}
+	return nil, false
+}
+
+var (
+	svgFontsOnce sync.Once
+	svgFonts     map[string]*opentype.Font
+	svgFontsErr  error
+)
+
+// svgFontFace returns a face of the Go font closest to the family and
+// weight, at size pixels.
+func svgFontFace(family string, weight string, size float64) (font.Face, error) {
+	svgFontsOnce.Do(func() {
+		svgFonts = make(map[string]*opentype.Font)
+		for name, ttf := range map[string][]byte{"regular": goregular.TTF, "bold": gobold.TTF, "mono": gomono.TTF} {
+			svgFonts[name], svgFontsErr = opentype.Parse(ttf)
+			if svgFontsErr != nil {
+				return
+			}
+		}
+	})
+	if svgFontsErr != nil {
+		return nil, errors.Wrap(svgFontsErr, "parse font")
+	}
+	f := svgFonts["regular"]
+	family = strings.ToLower(family)
+	switch {
+	case strings.Contains(family, "mono") || strings.Contains(family, "courier"):
+		f = svgFonts["mono"]
+	case isBoldFontWeight(weight):
+		f = svgFonts["bold"]
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	return face, errors.Wrap(err, "create font face")
+}
+
+func isBoldFontWeight(weight string) bool {
+	if weight == "bold" || weight == "bolder" {
+		return true
+	}
+	v, err := strconv.Atoi(weight)
+	return err == nil && v >= 600
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	if i := findAttr(attrs, name); i >= 0 {
+		return attrs[i].Value
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestRasterizeSVG(t *testing.T) {
+	tests := []struct {
+		name     string
+		svg      string
+		opts     RasterOptions
+		wantSize image.Point
+		wantErr  bool
+	}{
+		{
+			name:     "width and height",
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="20" viewBox="0 0 4 2"><rect width="4" height="2" fill="red"/></svg>`,
+			wantSize: image.Pt(40, 20),
+		},
+		{
+			name:     "points",
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg" width="30pt" height="15pt" viewBox="0 0 30 15"></svg>`,
+			wantSize: image.Pt(40, 20),
+		},
+		{
+			name:     "viewBox",
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 5"></svg>`,
+			wantSize: image.Pt(10, 5),
+		},
+		{
+			name:     "scale",
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="5"></svg>`,
+			opts:     RasterOptions{Scale: 2},
+			wantSize: image.Pt(20, 10),
+		},
+		{
+			name:    "no size",
+			svg:     `<svg xmlns="http://www.w3.org/2000/svg"></svg>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := RasterizeSVG([]byte(tt.svg), "png", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RasterizeSVG() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			img, err := png.Decode(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := img.Bounds().Size(); got != tt.wantSize {
+				t.Errorf("size = %v, want %v", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestParseSVGColor(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   color.Color
+		wantOK bool
+	}{
+		{s: "red", want: color.RGBA{0xff, 0x00, 0x00, 0xff}, wantOK: true},
+		{s: " Navy ", want: color.RGBA{0x00, 0x00, 0x80, 0xff}, wantOK: true},
+		{s: "rgb(1, 2, 300)", want: color.NRGBA{0x01, 0x02, 0xff, 0xff}, wantOK: true},
+		{s: "#102030", want: color.NRGBA{0x10, 0x20, 0x30, 0xff}, wantOK: true},
+		{s: "none"},
+		{s: "#xyz"},
+		{s: "url(#grad)"},
+	}
+	for _, tt := range tests {
+		got, ok := parseSVGColor(tt.s)
+		if ok != tt.wantOK {
+			t.Errorf("parseSVGColor(%q) ok = %v, want %v", tt.s, ok, tt.wantOK)
+			continue
+		}
+		if ok && got != tt.want {
+			t.Errorf("parseSVGColor(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
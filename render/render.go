@@ -0,0 +1,810 @@
+// Package render renders code blocks in markdown documents into images, and
+// inlines the images alongside the code blocks.
+package render
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Match: /optional/path/to/render-db6d08bb022ed12c2cc74d86d7a4707d.svg
+// Capture group on the hash.
+var renderedImageRegexp = regexp.MustCompile(`(?:^|/)render-(.{32})\.[^/]+$`)
+
+var renderedHashRegexp = regexp.MustCompile(`<!-- hash:(.{8}) -->`)
+
+// Match: ![alt text](filename.ext), ![alt text](filename.ext "title")
+// Capture groups on the alt text, the filename and the title.
+var markdownImageRegexp = regexp.MustCompile(`!\[((?:[^\]\\]|\\.)*)\]\((.+?)(?: "((?:[^"\\]|\\.)*)")?\)`)
+
+var (
+	markdownAltEscaper   = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+	markdownTitleEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+var (
+	defaultRenderMode    = "normal"
+	defaultRenderOptions = RenderOptions{Mode: defaultRenderMode}
+)
+
+type RenderOptions struct {
+	Mode     string     `json:"mode"` // Modes: normal, code-collapsed, image-collapsed, code-hidden, image-below, side-by-side, replace
+	Filename string     `json:"filename"`
+	Format   FormatList `json:"format"`  // Formats: svg, png, jpeg, pdf, webp
+	Dark     *bool      `json:"dark"`    // Whether to also render a dark variant. Overrides the document's default.
+	Inline   string     `json:"inline"`  // How to embed the image in the document: none, svg, data-uri. Overrides the document's default.
+	Width    string     `json:"width"`   // Explicit width of SVGs. Overrides the document's default.
+	Height   string     `json:"height"`  // Explicit height of SVGs. Overrides the document's default.
+	Alt      string     `json:"alt"`     // Alt text of the image. Defaults to the diagram's title.
+	Title    string     `json:"title"`   // Title of the image, usually shown as a tooltip
+	Caption  string     `json:"caption"` // Caption of the image, which makes it a numbered figure
+	ID       string     `json:"id"`      // Id of the figure, which cross-references such as @fig:id link to
+
+	// Options for raster images. Override the document's defaults.
+	Scale      float64 `json:"scale"`
+	DPI        int     `json:"dpi"`
+	Padding    int     `json:"padding"`
+	Background string  `json:"background"`
+}
+
+func (o *RenderOptions) Validate() error {
+	if o.Mode == "" {
+		o.Mode = defaultRenderMode
+	}
+	// Custom modes are checked against the document's templates later
+	if !modeNameRegexp.MatchString(o.Mode) {
+		return errors.New("unsupported mode")
+	}
+	err := o.Format.Validate()
+	if err != nil {
+		return err
+	}
+	if format, ok := formatForExt(path.Ext(o.Filename)); ok && len(o.Format) > 0 && o.Format[0] != format {
+		return errors.Errorf("filename %s doesn't match the %s format", o.Filename, o.Format[0])
+	}
+	err = ValidateInline(o.Inline)
+	if err != nil {
+		return err
+	}
+	err = validateFigureID(o.ID)
+	if err != nil {
+		return err
+	}
+	return RasterOptions{Scale: o.Scale, DPI: o.DPI, Padding: o.Padding, Background: o.Background}.Validate()
+}
+
+// Chunk represents a segment of a file
+type Chunk struct {
+	Lines          []string // Lines the chunk contains
+	StartLineIndex int      // Index is relative to the input file
+	EndLineIndex   int      // Index is relative to the input file
+	CodeBlockIndex int      // Primarily for logging, to identify the problematic code block
+
+	IsRenderable           bool
+	Language               string
+	ImageRelativeLineIndex int      // Where the image is located in the chunk. Index is relative to the chunk's lines.
+	RenderedHash           string   // If image has been rendered before, contains the hash of the code block previously used to render the image
+	RenderedFileName       string   // If image has been rendered before, contains the link to the image
+	RenderedDarkVariant    bool     // If image has been rendered before, whether a dark variant was rendered
+	RenderedInline         string   // If image has been rendered before, how it was embedded in the document, if at all
+	RenderedAlt            string   // If image has been rendered before, its alt text
+	RenderedTitle          string   // If image has been rendered before, its title
+	HasHashComment         bool     // Whether the image line has a hash comment, in layouts written before marker comments were introduced
+	CodeBlockContent       []string // The contents of the code block
+	CodeBlockFence         string   // Opening fence of the code block, including the render options
+	SourcePath             string   // In the "replace" mode, the path of the sidecar file holding the code block, relative to the document
+	RenderOptions          RenderOptions
+
+	DocumentName     string             // Name of the document containing the chunk
+	Index            int                // 1-based index of the chunk among renderable chunks in the document
+	Heading          string             // Slug of the nearest heading above the chunk
+	HeadingText      string             // Text of the nearest heading above the chunk
+	HeadingAnchor    string             // Anchor of the nearest heading above the chunk, as generated by GitHub
+	FilenameTemplate *template.Template // Template for the image's filename, if not the default
+	DefaultFormats   []string           // Formats to render to if the chunk doesn't specify any
+	ExtraFormats     []string           // Formats to also render to if the chunk's filename gives its format
+	DefaultDark      bool               // Whether to render a dark variant if the chunk doesn't specify
+	DefaultInline    string             // How to embed the image if the chunk doesn't specify
+	SVGOptions       SVGOptions         // Post-processing of rendered SVGs
+	RasterOptions    RasterOptions      // Options for raster images, if the chunk doesn't specify
+	CaptionStyle     string             // How captions are written: italic or figure
+	FigureNumber     int                // 1-based number of the chunk among figures in the document, if it is a figure
+	ModeTemplate     *template.Template // Template of the chunk's mode
+	LinkStyle        LinkStyle          // Syntax of the image. If empty, markdown images are written.
+}
+
+func (r *Chunk) ShouldRender() bool {
+	if !r.IsRenderable {
+		return false
+	}
+
+	// Render again to move the code block to its sidecar file
+	if r.RenderOptions.Mode == replaceMode && r.SourcePath == "" {
+		return true
+	}
+
+	// Support both a full hash (32 characters) and a short hash (8 characters)
+	hash := r.HashContent()
+	shortHash := hash[:8]
+	if r.HashContent() != r.RenderedHash && shortHash != r.RenderedHash {
+		return true
+	}
+	// Render again if the format, variants or embedding have changed
+	if r.RenderedFileName != "" && linkExt(r.RenderedFileName) != path.Ext(r.FileName()) {
+		return true
+	}
+	if r.RenderedDarkVariant != r.HasDarkVariant() || r.RenderedInline != r.Inline() {
+		return true
+	}
+	// Render again if the alt text or title have changed, since they are
+	// also embedded in SVGs. Inline SVGs only have them embedded.
+	if r.RenderedFileName != "" {
+		alt, title := r.AltText(), r.Title()
+		if !r.RenderedDarkVariant {
+			alt, title = r.readBack(alt, title, r.RenderedFileName)
+		}
+		if r.RenderedAlt != alt || r.RenderedTitle != title {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Chunk) HashContent() string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(r.CodeBlockContent, "\n"))))
+}
+
+// RenderKey identifies the image rendered from the chunk in format, or its
+// dark variant. Unlike the hash, it changes with the options that affect the
+// rendered image.
+func (r *Chunk) RenderKey(format string, dark bool) string {
+	b, _ := json.Marshal(struct {
+		Language string
+		Hash     string
+		Format   string
+		Dark     bool
+		SVG      SVGOptions
+		Raster   RasterOptions
+	}{r.Language, r.HashContent(), format, dark, r.svgOptions(), r.rasterOptions(dark)})
+	return fmt.Sprintf("%x", md5.Sum(b))
+}
+
+// FileName returns the filename of the image rendered from this chunk in its
+// primary format.
+func (r *Chunk) FileName() string {
+	return r.FileNameForFormat(r.Format())
+}
+
+// FileNames returns the filenames of the images rendered from this chunk, one
+// for each of its formats, starting with the primary format.
+func (r *Chunk) FileNames() []string {
+	var fileNames []string
+	for _, v := range r.Formats() {
+		fileNames = append(fileNames, r.FileNameForFormat(v))
+	}
+	return fileNames
+}
+
+// FileNameForFormat returns the filename of the image rendered from this
+// chunk in format. Images in formats other than the primary format are named
+// after the primary image, with the format's extension.
+func (r *Chunk) FileNameForFormat(format string) string {
+	if r.RenderOptions.Filename != "" {
+		if format == r.Format() {
+			return r.RenderOptions.Filename
+		}
+		return strings.TrimSuffix(r.RenderOptions.Filename, path.Ext(r.RenderOptions.Filename)) + "." + format
+	}
+	if r.FilenameTemplate != nil {
+		// Templates are validated when parsed, so this only fails for
+		// templates that were not created by ParseFilenameTemplate.
+		fileName, err := executeFilenameTemplate(r.FilenameTemplate, r.filenameTemplateData(format))
+		if err == nil {
+			return fileName
+		}
+	}
+	return "render-" + r.HashContent() + "." + format
+}
+
+// Render renders the chunk's code block to an image in outputDir for each of
+// its formats, and updates the chunk's lines to link to the primary image. If
+// the chunk has a dark variant, it is rendered alongside each image. Inlined
+// images are embedded in the chunk's lines instead of being written to files.
+// In the "replace" mode, the code block is moved to a sidecar file in
+// outputDir.
+func (r *Chunk) Render(outputDir string, linkPrefix string) (fileName string, err error) {
+	if r.RenderOptions.Mode == replaceMode {
+		err := r.writeSourceFile(outputDir)
+		if err != nil {
+			return "", err
+		}
+	}
+	if r.Inline() != "" {
+		return r.renderInline()
+	}
+	for _, format := range r.Formats() {
+		fileName := r.FileNameForFormat(format)
+		content, err := r.RenderFormat(format)
+		if err != nil {
+			return "", err
+		}
+		err = r.writeImage(outputDir, fileName, r.RenderKey(format, false), content)
+		if err != nil {
+			return "", err
+		}
+
+		if !r.HasDarkVariant() {
+			continue
+		}
+		content, err = r.RenderDarkFormat(format)
+		if err != nil {
+			return "", err
+		}
+		err = r.writeImage(outputDir, DarkFileName(fileName), r.RenderKey(format, true), content)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	fileName = r.FileName()
+	if r.HasDarkVariant() {
+		r.SetPicture(linkPrefix+fileName, linkPrefix+DarkFileName(fileName))
+	} else {
+		r.SetImage(linkPrefix + fileName)
+	}
+	return fileName, nil
+}
+
+// writeImage writes an image rendered from the chunk, and records it in the
+// output directory's manifest with its render key if its filename was
+// generated.
+func (r *Chunk) writeImage(outputDir string, fileName string, key string, content []byte) error {
+	err := WriteImage(outputDir, fileName, content)
+	if err != nil {
+		return err
+	}
+	if r.RenderOptions.Filename == "" {
+		return recordGeneratedImage(outputDir, fileName, key)
+	}
+	return nil
+}
+
+// WriteImageIfChanged renders the chunk's image in format, or its dark
+// variant, to fileName in outputDir. Images with generated filenames are
+// skipped if the manifest records that they were rendered with the same
+// render key, i.e. from the same code block and options. rendered is false if
+// the image was skipped.
+func (r *Chunk) WriteImageIfChanged(outputDir string, fileName string, format string, dark bool) (rendered bool, err error) {
+	key := r.RenderKey(format, dark)
+	if r.RenderOptions.Filename == "" {
+		m, err := ReadManifest(outputDir)
+		if err != nil {
+			return false, err
+		}
+		_, err = os.Stat(path.Join(outputDir, fileName))
+		if err == nil && m.Keys[fileName] == key {
+			return false, nil
+		}
+	}
+	content, err := r.renderFormat(format, dark)
+	if err != nil {
+		return false, err
+	}
+	return true, r.writeImage(outputDir, fileName, key, content)
+}
+
+// RenderContent runs the renderer for the chunk's language and returns the
+// rendered image in the primary format.
+func (r *Chunk) RenderContent() (content []byte, err error) {
+	return r.RenderFormat(r.Format())
+}
+
+// RenderFormat runs the renderer for the chunk's language and returns the
+// rendered image in format.
+func (r *Chunk) RenderFormat(format string) (content []byte, err error) {
+	return r.renderFormat(format, false)
+}
+
+func (r *Chunk) renderFormat(format string, dark bool) (content []byte, err error) {
+	codeBlockContent := strings.Join(r.CodeBlockContent, "\n")
+	args, rasterize, err := rendererFormatArgs(r.Language, format)
+	if err != nil {
+		return nil, err
+	}
+	if dark {
+		args = append(append([]string{}, args...), darkThemeArgs[r.Language]...)
+	}
+	rasterOptions := r.rasterOptions(dark)
+	if isRasterFormat(format) && !rasterize {
+		var nativeArgs []string
+		nativeArgs, rasterOptions = rasterArgs(r.Language, rasterOptions)
+		args = append(append([]string{}, args...), nativeArgs...)
+	}
+	switch r.Language {
+	case "dot":
+		content, err = runShellCommand("dot", args, strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render graphviz")
+		}
+	case "plantuml":
+		content, err = runShellCommand("plantuml", append(args, "-pipe"), strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render plantuml")
+		}
+	case "pikchr":
+		content, err = runShellCommand("pikchr", append(args, "-"), strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render pikchr")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", r.Language)
+	}
+	if rasterize {
+		content, err = RasterizeSVG(content, format, rasterOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "rasterize svg")
+		}
+		return content, nil
+	}
+	if format == "svg" {
+		content = addSVGTitle(content, r.AltText(), r.Title())
+	}
+	if svgOptions := r.svgOptions(); format == "svg" && !svgOptions.isZero() {
+		content, err = ProcessSVG(content, svgOptions, r.svgIDPrefix())
+		if err != nil {
+			return nil, errors.Wrap(err, "post-process svg")
+		}
+	}
+	if format == "png" && !rasterOptions.isZero() {
+		content, err = ProcessPNG(content, rasterOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "post-process png")
+		}
+	}
+	return content, nil
+}
+
+// SetImage updates the chunk's lines to display the image at link.
+func (r *Chunk) SetImage(link string) {
+	r.setImageLine(r.buildImage(r.AltText(), r.Title(), link))
+}
+
+// setImageLine replaces the chunk's image, by writing its layout again
+// around the image.
+func (r *Chunk) setImageLine(image string) {
+	r.setTemplateLines(image)
+}
+
+// Options configures how Process renders a document.
+type Options struct {
+	Name        string   // Name of the input, used when logging and in filename templates
+	Languages   []string // Languages to render
+	ForceRender bool     // Render all renderable chunks, even if they are unchanged
+
+	// FilenameTemplate is the template for the filenames of rendered
+	// images. See ParseFilenameTemplate. If empty, filenames are of the
+	// form render-{hash}.{format}.
+	FilenameTemplate string
+
+	// DefaultFormats are the formats to render code blocks to, if they
+	// don't specify any. The first format is linked to. Formats that a
+	// renderer doesn't support are skipped. If empty, svg is used. See
+	// Chunk.Formats.
+	DefaultFormats []string
+
+	// ExtraFormats are also rendered for code blocks whose filename option
+	// gives their format, e.g. the formats listed explicitly with
+	// --formats. See Chunk.Formats.
+	ExtraFormats []string
+
+	// Dark renders a dark variant of each image, if code blocks don't
+	// specify otherwise. Images with dark variants are displayed with
+	// <picture> elements.
+	Dark bool
+
+	// Inline embeds images in the document instead of writing them to
+	// files, if code blocks don't specify otherwise. See Chunk.Inline.
+	Inline string
+
+	SVG    SVGOptions    // Post-processing of rendered SVGs
+	Raster RasterOptions // Options for raster images
+
+	// CaptionStyle is how the captions of figures are written: "italic"
+	// for an italic line below the image, or "figure" for a <figure>
+	// element with a <figcaption>. If empty, italic is used.
+	CaptionStyle string
+
+	// ModeTemplates are the templates of custom modes, by mode name. See
+	// ParseModeTemplates.
+	ModeTemplates map[string]*template.Template
+
+	// LinkStyle is the syntax of the images that link to rendered files,
+	// e.g. one of LinkStyles. If empty, markdown images are written.
+	LinkStyle LinkStyle
+
+	// InputSetFigures returns the entries of the rendered images across
+	// the input set, for lists of figures that span it. Links must be
+	// relative to the document being processed. If nil, such lists only
+	// list the document's own images.
+	InputSetFigures func() ([]FigureListEntry, error)
+
+	// RenderChunk renders the chunk's image and updates the chunk's lines
+	// to link to it. Returns the image's filename.
+	RenderChunk func(chunk *Chunk) (fileName string, err error)
+
+	Log io.Writer // Progress is logged here, if set
+}
+
+// Process renders the code blocks in a document, and returns the updated
+// document.
+func Process(inputFileContent string, opts Options) (string, error) {
+	chunks, err := ParseChunks(inputFileContent, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// Render the renderable chunks
+	for _, chunk := range chunks {
+		if chunk.ShouldRender() || (chunk.IsRenderable && opts.ForceRender) {
+			imageFileName, err := opts.RenderChunk(chunk)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("line %d: render chunk", chunk.CodeBlockIndex+1))
+			}
+			if opts.Log != nil {
+				fmt.Fprintf(opts.Log, "[%s:%d] Rendered %s\n", opts.Name, chunk.CodeBlockIndex+1, imageFileName)
+			}
+		} else if chunk.IsRenderable {
+			chunk.refreshLayout()
+		}
+	}
+
+	unknown := updateFigureReferences(chunks, figureNumbers(chunks))
+	if opts.Log != nil {
+		for _, id := range unknown {
+			fmt.Fprintf(opts.Log, "[%s] Unknown figure reference @fig:%s\n", opts.Name, id)
+		}
+	}
+
+	err = updateFigureLists(chunks, opts.InputSetFigures)
+	if err != nil {
+		return "", err
+	}
+
+	// Join the chunks back into a file
+	var outputLines []string
+	for _, chunk := range chunks {
+		outputLines = append(outputLines, chunk.Lines...)
+	}
+
+	return strings.Join(outputLines, "\n"), nil
+}
+
+// ParseChunks splits a document into chunks. A chunk can represent either a
+// normal segment, or a renderable segment. Figures are numbered in document
+// order. Only the Name, Languages, FilenameTemplate, DefaultFormats,
+// ExtraFormats, Dark, Inline, SVG, Raster, CaptionStyle, ModeTemplates and
+// LinkStyle options are used.
+func ParseChunks(inputFileContent string, opts Options) ([]*Chunk, error) {
+	lines := strings.Split(inputFileContent, "\n")
+
+	var filenameTemplate *template.Template
+	if opts.FilenameTemplate != "" {
+		var err error
+		filenameTemplate, err = ParseFilenameTemplate(opts.FilenameTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := FormatList(opts.DefaultFormats).Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "validate default formats")
+	}
+	err = ValidateInline(opts.Inline)
+	if err != nil {
+		return nil, err
+	}
+	err = opts.Raster.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateCaptionStyle(opts.CaptionStyle)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct a lookup for O(1) access
+	typeLookup := make(map[string]bool)
+	for _, v := range opts.Languages {
+		typeLookup[v] = true
+	}
+
+	var chunks []*Chunk
+	var lastChunkIndex int
+	var renderableCount int
+	var heading, headingText, headingAnchor string
+	headingAnchors := make(map[string]int)
+	var inCodeBlock bool
+	for idx, line := range lines {
+		// Skip ahead if these lines have been assigned a chunk already
+		if idx < lastChunkIndex {
+			continue
+		}
+		// Track the nearest heading, for use in filename templates and
+		// lists of figures
+		if h, ok := parseHeading(line); ok && !inCodeBlock {
+			heading = slugify(h)
+			headingText = h
+			headingAnchor = uniqueHeadingAnchor(headingAnchors, h)
+		}
+		// Look for renderable code blocks, and code blocks moved to
+		// sidecar files
+		var renderChunk *Chunk
+		var language string
+		var err error
+		if strings.HasPrefix(line, "```") {
+			for k := range typeLookup {
+				if strings.HasPrefix(line, fmt.Sprintf("```%s render", k)) {
+					// Look at lines in and around the code
+					// block to determine the renderable chunk.
+					language = k
+					renderChunk, err = getRenderableChunk(lines, idx, k, opts, filenameTemplate)
+					break
+				}
+			}
+			if language == "" {
+				inCodeBlock = !inCodeBlock
+			}
+		} else if matches := sourceReferenceRegexp.FindStringSubmatch(strings.TrimSpace(line)); len(matches) == 3 && !inCodeBlock {
+			for k := range typeLookup {
+				if strings.HasPrefix(matches[2], k+" render") {
+					language = k
+					renderChunk, err = getSourceChunk(lines, idx, k, opts, filenameTemplate)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, &ParseError{LineIndex: idx, Err: err}
+		}
+		if renderChunk == nil {
+			continue
+		}
+		renderableCount++
+		renderChunk.Index = renderableCount
+		renderChunk.Heading = heading
+		renderChunk.HeadingText = headingText
+		renderChunk.HeadingAnchor = headingAnchor
+		for _, format := range renderChunk.Formats() {
+			if _, _, err := rendererFormatArgs(language, format); err != nil {
+				return nil, &ParseError{LineIndex: idx, Err: err}
+			}
+		}
+		if err := renderChunk.validateInline(); err != nil {
+			return nil, &ParseError{LineIndex: idx, Err: err}
+		}
+		// Preceding lines not part of the renderable chunk are part of a
+		// normal chunk; construct one and add it to our list of chunks.
+		normalChunk := &Chunk{
+			StartLineIndex: lastChunkIndex,
+			EndLineIndex:   renderChunk.StartLineIndex - 1,
+		}
+		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
+		chunks = append(chunks, normalChunk, renderChunk)
+		lastChunkIndex = renderChunk.EndLineIndex + 1
+	}
+	if lastChunkIndex < len(lines) {
+		// The rest of the file is a normal chunk
+		normalChunk := &Chunk{
+			StartLineIndex: lastChunkIndex,
+			EndLineIndex:   len(lines) - 1,
+		}
+		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
+		chunks = append(chunks, normalChunk)
+	}
+
+	err = numberFigures(chunks)
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// ParseError is returned by ParseChunks when a renderable code block is
+// invalid.
+type ParseError struct {
+	LineIndex int // Index of the code block's opening fence
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: get renderable chunk: %s", e.LineIndex, e.Err)
+}
+
+// NewChunk returns a renderable chunk for a code block's content, for use
+// outside of a markdown document.
+func NewChunk(language string, content string, renderOptions RenderOptions) *Chunk {
+	return &Chunk{
+		IsRenderable:     true,
+		Language:         language,
+		CodeBlockContent: strings.Split(content, "\n"),
+		RenderOptions:    renderOptions,
+	}
+}
+
+// ParseInfo parses the info string of a fenced code block, e.g.
+// `dot render{"mode": "code-collapsed"}`. ok is false if the code block is not
+// marked for rendering in one of the given languages.
+func ParseInfo(info string, languages []string) (language string, renderOptions RenderOptions, ok bool, err error) {
+	for _, v := range languages {
+		prefix := v + " render"
+		if !strings.HasPrefix(info, prefix) {
+			continue
+		}
+		renderOptions, err = parseRenderOptions(strings.TrimPrefix(info, prefix))
+		if err != nil {
+			return "", RenderOptions{}, false, err
+		}
+		return v, renderOptions, true, nil
+	}
+	return "", RenderOptions{}, false, nil
+}
+
+// parseRenderOptions parses the JSON options following the render keyword.
+// Default options are returned if there are none.
+func parseRenderOptions(renderOptionsJSON string) (RenderOptions, error) {
+	if !strings.HasPrefix(renderOptionsJSON, "{") || !strings.HasSuffix(renderOptionsJSON, "}") {
+		return defaultRenderOptions, nil
+	}
+	var renderOptions RenderOptions
+	err := json.Unmarshal([]byte(renderOptionsJSON), &renderOptions)
+	if err != nil {
+		return RenderOptions{}, errors.Wrap(err, "unmarshal render options")
+	}
+	err = renderOptions.Validate()
+	if err != nil {
+		return RenderOptions{}, errors.Wrap(err, "validate render options")
+	}
+	return renderOptions, nil
+}
+
+func getRenderableChunk(lines []string, codeBlockIndex int, language string, opts Options, filenameTemplate *template.Template) (*Chunk, error) {
+	chunk, err := newRenderableChunk(lines[codeBlockIndex], codeBlockIndex, language, opts, filenameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	err = RenderTemplateManager{}.Parse(lines, codeBlockIndex, chunk)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse render template")
+	}
+	return chunk, nil
+}
+
+// getSourceChunk returns the chunk of a code block that was moved to a
+// sidecar file in the "replace" mode, and which is referenced by the marker
+// comment at referenceIndex.
+func getSourceChunk(lines []string, referenceIndex int, language string, opts Options, filenameTemplate *template.Template) (*Chunk, error) {
+	matches := sourceReferenceRegexp.FindStringSubmatch(strings.TrimSpace(lines[referenceIndex]))
+	fence := "```" + matches[2]
+	chunk, err := newRenderableChunk(fence, referenceIndex, language, opts, filenameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	content, err := readSourceFile(opts.Name, matches[1])
+	if err != nil {
+		return nil, err
+	}
+	chunk.CodeBlockContent = content
+	chunk.CodeBlockFence = fence
+	chunk.SourcePath = matches[1]
+	RenderTemplateManager{}.ParseSource(lines, referenceIndex, chunk)
+	return chunk, nil
+}
+
+// newRenderableChunk returns a chunk for the code block opened by fence,
+// with its options read from the fence and the document's defaults.
+func newRenderableChunk(fence string, codeBlockIndex int, language string, opts Options, filenameTemplate *template.Template) (*Chunk, error) {
+	chunk := &Chunk{}
+	chunk.IsRenderable = true
+	chunk.Language = language
+	chunk.CodeBlockIndex = codeBlockIndex
+
+	renderOptions, err := parseRenderOptions(strings.TrimPrefix(fence, fmt.Sprintf("```%s render", language)))
+	if err != nil {
+		return nil, err
+	}
+	chunk.RenderOptions = renderOptions
+
+	chunk.FilenameTemplate = filenameTemplate
+	chunk.DefaultFormats = opts.DefaultFormats
+	chunk.ExtraFormats = opts.ExtraFormats
+	chunk.DefaultDark = opts.Dark
+	chunk.DefaultInline = opts.Inline
+	chunk.SVGOptions = opts.SVG
+	chunk.RasterOptions = opts.Raster
+	chunk.CaptionStyle = opts.CaptionStyle
+	chunk.DocumentName = opts.Name
+	chunk.LinkStyle = opts.LinkStyle
+	chunk.ModeTemplate = builtinModeTemplates[chunk.RenderOptions.Mode]
+	if chunk.ModeTemplate == nil {
+		chunk.ModeTemplate = opts.ModeTemplates[chunk.RenderOptions.Mode]
+	}
+	if chunk.ModeTemplate == nil {
+		return nil, errors.Errorf("unsupported mode %q", chunk.RenderOptions.Mode)
+	}
+
+	// Layouts written before marker comments were introduced have a hash
+	// comment if the filename may not contain the hash. Marker comments
+	// hold the hash instead.
+	if chunk.RenderOptions.Filename != "" || chunk.FilenameTemplate != nil || chunk.Inline() != "" {
+		chunk.HasHashComment = true
+	}
+	return chunk, nil
+}
+
+// runShellCommand runs the command and returns its output. If the command
+// fails, its stderr output is included in the returned error.
+func runShellCommand(command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
+	cmd := exec.Command(command, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	cmd.Stdin = stdin
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	err = cmd.Run()
+	if err != nil && stderr.Len() > 0 {
+		return stdout.Bytes(), errors.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// Warnings from successful runs are passed through
+	os.Stderr.Write(stderr.Bytes())
+	return stdout.Bytes(), err
+}
+
+// WriteImage writes a rendered image to outputDir, creating the directory if
+// necessary.
+func WriteImage(outputDir string, fileName string, content []byte) error {
+	if outputDir != "" {
+		err := os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			return errors.Wrap(err, "create output directory")
+		}
+	}
+	outputFilePath := path.Join(outputDir, fileName)
+	f, err := os.Create(outputFilePath)
+	if err != nil {
+		return errors.Wrap(err, "create output file")
+	}
+	defer f.Close()
+	_, err = f.Write(content)
+	return errors.Wrap(err, "write output file")
+}
+
+func buildMarkdownImage(alt string, title string, link string) string {
+	if title == "" {
+		return fmt.Sprintf("![%s](%s)", markdownAltEscaper.Replace(alt), link)
+	}
+	return fmt.Sprintf(`![%s](%s "%s")`, markdownAltEscaper.Replace(alt), link, markdownTitleEscaper.Replace(title))
+}
+
+// unescapeMarkdown removes backslash escapes.
+func unescapeMarkdown(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"testing"
+)
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		info         string
+		wantLanguage string
+		wantMode     string
+		wantFilename string
+		wantOK       bool
+		wantErr      bool
+	}{
+		{info: "dot render", wantLanguage: "dot", wantMode: "normal", wantOK: true},
+		{info: `dot render{"mode": "code-collapsed"}`, wantLanguage: "dot", wantMode: "code-collapsed", wantOK: true},
+		{info: `plantuml render{"filename": "a.png"}`, wantLanguage: "plantuml", wantMode: "normal", wantFilename: "a.png", wantOK: true},
+		{info: "dot"},
+		{info: "mermaid render"},
+		{info: `dot render{"mode": "side-note"}`, wantLanguage: "dot", wantMode: "side-note", wantOK: true},
+		{info: `dot render{"format": "gif"}`, wantErr: true},
+		{info: `dot render{"mode": }`, wantErr: true},
+	}
+	for _, tt := range tests {
+		language, renderOptions, ok, err := ParseInfo(tt.info, []string{"dot", "plantuml"})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseInfo(%q) error = %v, want error %v", tt.info, err, tt.wantErr)
+			continue
+		}
+		if language != tt.wantLanguage || renderOptions.Mode != tt.wantMode || renderOptions.Filename != tt.wantFilename || ok != tt.wantOK {
+			t.Errorf("ParseInfo(%q) = %q, %+v, %v, want %q, mode %q, filename %q, %v", tt.info, language, renderOptions, ok, tt.wantLanguage, tt.wantMode, tt.wantFilename, tt.wantOK)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	const (
+		image  = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		layout = image + "\n\n```dot render\ndigraph { a -> b }\n```"
+	)
+	tests := []struct {
+		name        string
+		doc         string
+		forceRender bool
+		want        string
+		wantRenders int
+	}{
+		{
+			name:        "new code block",
+			doc:         "# Doc\n\n```dot render\ndigraph { a -> b }\n```",
+			want:        "# Doc\n\n" + region("normal", "82682d8f", layout),
+			wantRenders: 1,
+		},
+		{
+			name: "rendered before",
+			doc:  "# Doc\n\n" + region("normal", "82682d8f", layout),
+			want: "# Doc\n\n" + region("normal", "82682d8f", layout),
+		},
+		{
+			name: "legacy layout",
+			doc:  "# Doc\n\n" + layout,
+			want: "# Doc\n\n" + region("normal", "82682d8f", layout),
+		},
+		{
+			name:        "forced",
+			doc:         "# Doc\n\n" + region("normal", "82682d8f", layout),
+			forceRender: true,
+			want:        "# Doc\n\n" + region("normal", "82682d8f", layout),
+			wantRenders: 1,
+		},
+		{
+			name:        "code changed",
+			doc:         "# Doc\n\n" + region("normal", "82682d8f", image+"\n\n```dot render\ndigraph { a -> c }\n```"),
+			want:        "# Doc\n\n" + region("normal", "04579f4a", "![Graphviz diagram](render-04579f4a9b61bbc54c6661af818353c5.svg)\n\n```dot render\ndigraph { a -> c }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "image-below",
+			doc:         "```dot render{\"mode\": \"image-below\"}\ndigraph { a -> b }\n```",
+			want:        region("image-below", "82682d8f", "```dot render{\"mode\": \"image-below\"}\ndigraph { a -> b }\n```\n\n"+image),
+			wantRenders: 1,
+		},
+		{
+			name:        "side-by-side",
+			doc:         "```dot render{\"mode\": \"side-by-side\"}\ndigraph { a -> b }\n```",
+			want:        region("side-by-side", "82682d8f", "<table><tr><td>\n\n```dot render{\"mode\": \"side-by-side\"}\ndigraph { a -> b }\n```\n\n</td><td>\n\n"+image+"\n\n</td></tr></table>"),
+			wantRenders: 1,
+		},
+		{
+			name: "other languages",
+			doc:  "```plantuml render\n@startuml\n@enduml\n```",
+			want: "```plantuml render\n@startuml\n@enduml\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renders int
+			got, err := Process(tt.doc, Options{
+				Languages:   []string{"dot"},
+				ForceRender: tt.forceRender,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+		})
+	}
+}
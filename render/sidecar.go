@@ -0,0 +1,134 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// replaceMode moves the code block to a sidecar file, leaving the image and a
+// reference to the sidecar file in the document.
+const replaceMode = "replace"
+
+// Match: <!-- md-code-renderer:source assets/diagram-db6d08bb.dot dot render{"mode":"replace"} -->
+// Capture groups on the path of the sidecar file and the info string of the
+// code block.
+var sourceReferenceRegexp = regexp.MustCompile(`^<!-- md-code-renderer:source (.+?) (\S+ render.*) -->$`)
+
+// Extensions of sidecar files, by language
+var sourceFileExts = map[string]string{
+	"dot":      "dot",
+	"plantuml": "puml",
+	"pikchr":   "pikchr",
+}
+
+// sourceFileName returns the filename of the sidecar file that the code block
+// is moved to in the "replace" mode, e.g. diagram-db6d08bb.puml.
+func (r *Chunk) sourceFileName() string {
+	ext, ok := sourceFileExts[r.Language]
+	if !ok {
+		ext = r.Language
+	}
+	return fmt.Sprintf("diagram-%s.%s", r.HashContent()[:8], ext)
+}
+
+// sourceReference returns the marker comment that takes the place of the code
+// block in the "replace" mode. It holds the path of the sidecar file and the
+// info string of the code block, so that the code block can be restored.
+func (r *Chunk) sourceReference() string {
+	return fmt.Sprintf("<!-- md-code-renderer:source %s %s -->", r.SourcePath, strings.TrimPrefix(r.CodeBlockFence, "```"))
+}
+
+// writeSourceFile moves the code block to a sidecar file in outputDir, unless
+// it was read from one. Sidecar files are recorded in the output directory's
+// manifest, so that clean removes them once they are no longer referenced.
+func (r *Chunk) writeSourceFile(outputDir string) error {
+	if r.SourcePath != "" {
+		return nil
+	}
+	fileName := r.sourceFileName()
+	err := WriteImage(outputDir, fileName, []byte(strings.Join(r.CodeBlockContent, "\n")+"\n"))
+	if err != nil {
+		return errors.Wrap(err, "write source file")
+	}
+	err = recordGeneratedImage(outputDir, fileName, "")
+	if err != nil {
+		return err
+	}
+
+	// Paths are relative to the document
+	absDocumentDir, err := filepath.Abs(filepath.Dir(r.DocumentName))
+	if err != nil {
+		return errors.Wrap(err, "resolve document directory")
+	}
+	absSourcePath, err := filepath.Abs(filepath.Join(outputDir, fileName))
+	if err != nil {
+		return errors.Wrap(err, "resolve source file")
+	}
+	rel, err := filepath.Rel(absDocumentDir, absSourcePath)
+	if err != nil {
+		return errors.Wrap(err, "compute relative path")
+	}
+	r.SourcePath = filepath.ToSlash(rel)
+	return nil
+}
+
+// SourceFile returns the path of the sidecar file holding the chunk's code
+// block in the "replace" mode, or an empty string if the code block is in
+// the document. Relative paths are relative to the working directory.
+func (r *Chunk) SourceFile() string {
+	if r.SourcePath == "" {
+		return ""
+	}
+	return sourceFilePath(r.DocumentName, r.SourcePath)
+}
+
+func sourceFilePath(documentName string, sourcePath string) string {
+	filePath := filepath.FromSlash(sourcePath)
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(filepath.Dir(documentName), filePath)
+	}
+	return filePath
+}
+
+// readSourceFile reads the lines of a code block from the sidecar file at
+// sourcePath, relative to the document.
+func readSourceFile(documentName string, sourcePath string) ([]string, error) {
+	b, err := os.ReadFile(sourceFilePath(documentName, sourcePath))
+	if err != nil {
+		return nil, errors.Wrap(err, "read source file")
+	}
+	content := strings.TrimSuffix(string(b), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
+
+// InlineSources moves the code blocks of the "replace" mode back into the
+// document from their sidecar files, so that they can be edited. The code
+// blocks are moved to sidecar files again when they are next rendered. The
+// sidecar files are left in place for clean to remove.
+func InlineSources(inputFileContent string, opts Options) (string, error) {
+	chunks, err := ParseChunks(inputFileContent, opts)
+	if err != nil {
+		return "", err
+	}
+	var outputLines []string
+	for _, chunk := range chunks {
+		if chunk.SourcePath != "" {
+			image := imagePlaceholder
+			if parsed, ok := chunk.parseImageLine(chunk.Lines[chunk.ImageRelativeLineIndex]); ok {
+				image = chunk.rebuildImage(parsed)
+			}
+			chunk.SourcePath = ""
+			chunk.setTemplateLines(image)
+		}
+		outputLines = append(outputLines, chunk.Lines...)
+	}
+	return strings.Join(outputLines, "\n"), nil
+}
@@ -0,0 +1,93 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessReplaceMode(t *testing.T) {
+	const (
+		fence  = "```dot render{\"mode\": \"replace\"}"
+		imageB = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		imageC = "![Graphviz diagram](render-04579f4a9b61bbc54c6661af818353c5.svg)"
+	)
+	replacedB := region("replace", "82682d8f", imageB+"\n\n<!-- md-code-renderer:source diagram-82682d8f.dot dot render{\"mode\": \"replace\"} -->")
+	tests := []struct {
+		name        string
+		doc         string
+		want        string
+		wantSource  string // Name of the sidecar file
+		wantInlined string // Document after InlineSources
+		wantRenders int
+	}{
+		{
+			name:        "code block",
+			doc:         "# Doc\n\n" + fence + "\ndigraph { a -> b }\n```",
+			want:        "# Doc\n\n" + replacedB,
+			wantSource:  "diagram-82682d8f.dot",
+			wantInlined: "# Doc\n\n" + region("replace", "82682d8f", imageB+"\n\n"+fence+"\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "edited after inlining",
+			doc:         region("replace", "82682d8f", imageB+"\n\n"+fence+"\ndigraph { a -> c }\n```"),
+			want:        region("replace", "04579f4a", imageC+"\n\n<!-- md-code-renderer:source diagram-04579f4a.dot dot render{\"mode\": \"replace\"} -->"),
+			wantSource:  "diagram-04579f4a.dot",
+			wantInlined: region("replace", "04579f4a", imageC+"\n\n"+fence+"\ndigraph { a -> c }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "rendered before",
+			doc:         replacedB,
+			want:        replacedB,
+			wantSource:  "diagram-82682d8f.dot",
+			wantInlined: region("replace", "82682d8f", imageB+"\n\n"+fence+"\ndigraph { a -> b }\n```"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The sidecar file of the layouts rendered before
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "diagram-82682d8f.dot"), []byte("digraph { a -> b }\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var renders int
+			opts := Options{
+				Name:      filepath.Join(dir, "doc.md"),
+				Languages: []string{"dot"},
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					err := chunk.writeSourceFile(dir)
+					if err != nil {
+						return "", err
+					}
+					chunk.SetImage(chunk.FileName())
+					return chunk.FileName(), nil
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.wantSource)); err != nil {
+				t.Error(err)
+			}
+
+			inlined, err := InlineSources(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inlined != tt.wantInlined {
+				t.Errorf("InlineSources() =\n%s\nwant\n%s", inlined, tt.wantInlined)
+			}
+		})
+	}
+}
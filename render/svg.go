@@ -0,0 +1,408 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SVGOptions configures the post-processing of rendered SVGs.
+type SVGOptions struct {
+	Minify    bool   // Remove comments, the doctype, redundant attributes and whitespace between elements
+	PrefixIDs bool   // Prefix ids, and references to them, with the code block's hash, so that they don't collide when several SVGs are inlined on one page
+	Sanitize  bool   // Keep only static SVG elements and links with safe schemes, and remove event handlers
+	Width     string // Explicit width of the SVG, e.g. "100%"
+	Height    string // Explicit height of the SVG
+}
+
+func (o SVGOptions) isZero() bool {
+	return o == SVGOptions{}
+}
+
+var (
+	// Match: url(#id)
+	// Capture group on the id.
+	svgURLReferenceRegexp = regexp.MustCompile(`url\(\s*#([^)\s]+)\s*\)`)
+
+	// Match: 10pt, 10.5px, 10, -10
+	// Capture groups on the number and the unit.
+	svgLengthRegexp = regexp.MustCompile(`^\s*(-?[0-9.]+)\s*(px|pt)?\s*$`)
+)
+
+// Attributes that are redundant in rendered SVGs
+var svgRedundantAttributes = map[string]bool{
+	"baseProfile":       true,
+	"contentScriptType": true,
+	"contentStyleType":  true,
+	"version":           true,
+	"zoomAndPan":        true,
+}
+
+// Elements that are kept when sanitizing. Other elements, such as scripts,
+// foreign objects and animations, are removed along with their contents.
+var svgSafeElements = map[string]bool{
+	"svg": true, "g": true, "defs": true, "symbol": true, "use": true, "switch": true, "view": true,
+	"title": true, "desc": true, "metadata": true, "style": true, "a": true, "image": true,
+	"path": true, "rect": true, "circle": true, "ellipse": true, "line": true, "polyline": true, "polygon": true,
+	"text": true, "tspan": true, "textPath": true,
+	"linearGradient": true, "radialGradient": true, "stop": true, "pattern": true,
+	"clipPath": true, "mask": true, "marker": true,
+	"filter": true, "feBlend": true, "feColorMatrix": true, "feComponentTransfer": true, "feComposite": true,
+	"feConvolveMatrix": true, "feDiffuseLighting": true, "feDisplacementMap": true, "feDistantLight": true,
+	"feDropShadow": true, "feFlood": true, "feFuncA": true, "feFuncB": true, "feFuncG": true, "feFuncR": true,
+	"feGaussianBlur": true, "feMerge": true, "feMergeNode": true, "feMorphology": true, "feOffset": true,
+	"fePointLight": true, "feSpecularLighting": true, "feSpotLight": true, "feTile": true, "feTurbulence": true,
+}
+
+// Attributes holding URLs, which must use a safe scheme when sanitizing
+var svgURLAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"base":   true,
+	"action": true,
+}
+
+var (
+	// Match: https:, mailto:, data:
+	// Capture group on the scheme.
+	urlSchemeRegexp = regexp.MustCompile(`^([a-z][a-z0-9+.-]*):`)
+
+	// Match: data:image/png;base64,...
+	safeDataURLRegexp = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp)[;,]`)
+)
+
+// URL schemes that are kept when sanitizing. Links without a scheme are
+// relative, and are kept too.
+var safeURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// Elements whose text is significant, and is kept as-is when minifying
+var svgTextElements = map[string]bool{
+	"text":  true,
+	"tspan": true,
+	"title": true,
+	"desc":  true,
+	"style": true,
+}
+
+// svgOptions returns the post-processing options for the chunk, with the
+// chunk's width and height options taking precedence.
+func (r *Chunk) svgOptions() SVGOptions {
+	opts := r.SVGOptions
+	if r.RenderOptions.Width != "" {
+		opts.Width = r.RenderOptions.Width
+	}
+	if r.RenderOptions.Height != "" {
+		opts.Height = r.RenderOptions.Height
+	}
+	return opts
+}
+
+// svgIDPrefix returns the prefix for ids in the chunk's SVGs. IDs must start
+// with a letter, so the hash alone can't be used.
+func (r *Chunk) svgIDPrefix() string {
+	return "r" + r.HashContent()[:8] + "-"
+}
+
+// ProcessSVG post-processes an SVG according to opts. If IDs are prefixed,
+// idPrefix is prepended to every id and reference to one.
+func ProcessSVG(svg []byte, opts SVGOptions, idPrefix string) ([]byte, error) {
+	p := svgProcessor{opts: opts, idPrefix: idPrefix}
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Entity = xml.HTMLEntity
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parse svg")
+		}
+		p.process(token)
+	}
+	p.closePendingStart()
+	return p.out.Bytes(), nil
+}
+
+// svgProcessor serializes SVG tokens, transforming them along the way. Tokens
+// are serialized by hand rather than with xml.Encoder, which doesn't
+// preserve namespace prefixes such as xlink:href.
+type svgProcessor struct {
+	opts     SVGOptions
+	idPrefix string
+	out      bytes.Buffer
+
+	stack        []string // Names of open elements
+	skipDepth    int      // Depth of the removed element being skipped, if any
+	pendingStart bool     // Whether the last start tag is unclosed, so that empty elements can be self-closing
+	seenRoot     bool
+}
+
+func (p *svgProcessor) process(token xml.Token) {
+	if p.skipDepth > 0 {
+		switch token.(type) {
+		case xml.StartElement:
+			p.skipDepth++
+		case xml.EndElement:
+			p.skipDepth--
+		}
+		return
+	}
+
+	switch t := token.(type) {
+	case xml.StartElement:
+		name := xmlName(t.Name)
+		if p.opts.Sanitize && !svgSafeElements[t.Name.Local] {
+			p.skipDepth = 1
+			return
+		}
+		p.closePendingStart()
+		attrs := p.processAttrs(t)
+		p.out.WriteString("<" + name)
+		for _, v := range attrs {
+			fmt.Fprintf(&p.out, ` %s="%s"`, xmlName(v.Name), escapeXMLAttr(v.Value))
+		}
+		p.stack = append(p.stack, t.Name.Local)
+		p.pendingStart = true
+	case xml.EndElement:
+		if len(p.stack) > 0 {
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+		if p.pendingStart {
+			p.out.WriteString("/>")
+			p.pendingStart = false
+			return
+		}
+		p.out.WriteString("</" + xmlName(t.Name) + ">")
+	case xml.CharData:
+		text := string(t)
+		if p.opts.Minify && !p.inTextElement() && strings.TrimSpace(text) == "" {
+			return
+		}
+		p.closePendingStart()
+		if p.inElement("style") {
+			if p.opts.PrefixIDs {
+				text = p.prefixURLReferences(text)
+			}
+			p.out.WriteString(escapeXMLStyle(text))
+			return
+		}
+		p.out.WriteString(escapeXMLText(text))
+	case xml.Comment:
+		if p.opts.Minify {
+			return
+		}
+		p.closePendingStart()
+		p.out.WriteString("<!--" + string(t) + "-->")
+	case xml.ProcInst:
+		if p.opts.Minify {
+			return
+		}
+		p.closePendingStart()
+		fmt.Fprintf(&p.out, "<?%s %s?>", t.Target, t.Inst)
+	case xml.Directive:
+		if p.opts.Minify {
+			return
+		}
+		p.closePendingStart()
+		p.out.WriteString("<!" + string(t) + ">")
+	}
+}
+
+// processAttrs returns the element's attributes, transformed according to
+// the options.
+func (p *svgProcessor) processAttrs(t xml.StartElement) []xml.Attr {
+	var attrs []xml.Attr
+	for _, v := range t.Attr {
+		if p.opts.Minify && svgRedundantAttributes[v.Name.Local] && v.Name.Space == "" {
+			continue
+		}
+		if p.opts.Sanitize && isUnsafeSVGAttr(v) {
+			continue
+		}
+		if p.opts.PrefixIDs {
+			v.Value = p.prefixAttr(v)
+		}
+		attrs = append(attrs, v)
+	}
+
+	// Dimensions are only set on the root element
+	if t.Name.Local != "svg" || p.seenRoot {
+		return attrs
+	}
+	p.seenRoot = true
+	if p.opts.Width == "" && p.opts.Height == "" {
+		return attrs
+	}
+	// Keep a viewBox, so that the SVG scales to the new dimensions
+	if viewBox, ok := svgViewBox(attrs); ok && findAttr(attrs, "viewBox") < 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "viewBox"}, Value: viewBox})
+	}
+	attrs = setAttr(attrs, "width", p.opts.Width)
+	attrs = setAttr(attrs, "height", p.opts.Height)
+	return attrs
+}
+
+func (p *svgProcessor) prefixAttr(attr xml.Attr) string {
+	switch attr.Name.Local {
+	case "id":
+		return p.idPrefix + attr.Value
+	case "href":
+		if strings.HasPrefix(attr.Value, "#") {
+			return "#" + p.idPrefix + strings.TrimPrefix(attr.Value, "#")
+		}
+		return attr.Value
+	case "aria-labelledby", "aria-describedby":
+		ids := strings.Fields(attr.Value)
+		for i := range ids {
+			ids[i] = p.idPrefix + ids[i]
+		}
+		return strings.Join(ids, " ")
+	}
+	return p.prefixURLReferences(attr.Value)
+}
+
+func (p *svgProcessor) prefixURLReferences(text string) string {
+	return svgURLReferenceRegexp.ReplaceAllString(text, "url(#"+p.idPrefix+"$1)")
+}
+
+func (p *svgProcessor) closePendingStart() {
+	if p.pendingStart {
+		p.out.WriteString(">")
+		p.pendingStart = false
+	}
+}
+
+func (p *svgProcessor) inTextElement() bool {
+	for _, v := range p.stack {
+		if svgTextElements[v] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *svgProcessor) inElement(name string) bool {
+	return len(p.stack) > 0 && p.stack[len(p.stack)-1] == name
+}
+
+func isUnsafeSVGAttr(attr xml.Attr) bool {
+	if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") {
+		return true
+	}
+	if svgURLAttributes[strings.ToLower(attr.Name.Local)] {
+		return !isSafeURL(attr.Value)
+	}
+	return false
+}
+
+// isSafeURL returns whether the URL is relative, or uses a safe scheme.
+// Browsers ignore whitespace and control characters in schemes, e.g.
+// "java\tscript:", so they are ignored here too.
+func isSafeURL(url string) bool {
+	url = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url))
+	matches := urlSchemeRegexp.FindStringSubmatch(url)
+	if matches == nil {
+		return true
+	}
+	if matches[1] == "data" {
+		return safeDataURLRegexp.MatchString(url)
+	}
+	return safeURLSchemes[matches[1]]
+}
+
+// svgViewBox derives a viewBox from the SVG's width and height, if they are
+// absolute lengths.
+func svgViewBox(attrs []xml.Attr) (string, bool) {
+	widthIndex, heightIndex := findAttr(attrs, "width"), findAttr(attrs, "height")
+	if widthIndex < 0 || heightIndex < 0 {
+		return "", false
+	}
+	width, ok := parseSVGLength(attrs[widthIndex].Value)
+	if !ok {
+		return "", false
+	}
+	height, ok := parseSVGLength(attrs[heightIndex].Value)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("0 0 %s %s", formatSVGNumber(width), formatSVGNumber(height)), true
+}
+
+func parseSVGLength(length string) (float64, bool) {
+	matches := svgLengthRegexp.FindStringSubmatch(length)
+	if len(matches) != 3 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(matches[1], 64)
+	return v, err == nil
+}
+
+func formatSVGNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func findAttr(attrs []xml.Attr, name string) int {
+	for i, v := range attrs {
+		if v.Name.Space == "" && v.Name.Local == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// setAttr sets the attribute's value, adding it if necessary. Empty values
+// leave the attribute as-is.
+func setAttr(attrs []xml.Attr, name string, value string) []xml.Attr {
+	if value == "" {
+		return attrs
+	}
+	if i := findAttr(attrs, name); i >= 0 {
+		attrs[i].Value = value
+		return attrs
+	}
+	return append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+func escapeXMLText(text string) string {
+	return xmlTextEscaper.Replace(text)
+}
+
+func escapeXMLAttr(value string) string {
+	return xmlAttrEscaper.Replace(value)
+}
+
+// escapeXMLStyle escapes the text of a <style> element. CSS such as child
+// selectors is kept readable in a CDATA section rather than escaped.
+func escapeXMLStyle(text string) string {
+	if !strings.ContainsAny(text, "<>&") {
+		return text
+	}
+	return "<![CDATA[" + strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
@@ -0,0 +1,136 @@
+package render
+
+import "testing"
+
+func TestProcessSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		opts SVGOptions
+		want string
+	}{
+		{
+			name: "no options",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg"><g id="node1"><title>a</title></g></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><g id="node1"><title>a</title></g></svg>`,
+		},
+		{
+			name: "prefix ids",
+			svg:  `<svg><g id="node1"/></svg>`,
+			opts: SVGOptions{PrefixIDs: true},
+			want: `<svg><g id="r1234abcd-node1"/></svg>`,
+		},
+		{
+			name: "prefix href references",
+			svg:  `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#a"/><a href="https://example.com/#b"/></svg>`,
+			opts: SVGOptions{PrefixIDs: true},
+			want: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#r1234abcd-a"/><a href="https://example.com/#b"/></svg>`,
+		},
+		{
+			name: "prefix url references",
+			svg:  `<svg><path fill="url(#grad)" clip-path="url(#clip)"/></svg>`,
+			opts: SVGOptions{PrefixIDs: true},
+			want: `<svg><path fill="url(#r1234abcd-grad)" clip-path="url(#r1234abcd-clip)"/></svg>`,
+		},
+		{
+			name: "prefix aria references",
+			svg:  `<svg aria-labelledby="title desc"/>`,
+			opts: SVGOptions{PrefixIDs: true},
+			want: `<svg aria-labelledby="r1234abcd-title r1234abcd-desc"/>`,
+		},
+		{
+			name: "prefix url references in style",
+			svg:  `<svg><style>.a { fill: url(#grad); }</style></svg>`,
+			opts: SVGOptions{PrefixIDs: true},
+			want: `<svg><style>.a { fill: url(#r1234abcd-grad); }</style></svg>`,
+		},
+		{
+			name: "sanitize elements",
+			svg:  `<svg><script>alert(1)</script><foreignObject><div><p>x</p></div></foreignObject><g/></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><g/></svg>`,
+		},
+		{
+			name: "sanitize attributes",
+			svg:  `<svg onload="alert(1)"><a href="javascript:alert(1)" onClick="x"/><a href="#ok"/></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><a/><a href="#ok"/></svg>`,
+		},
+		{
+			name: "sanitize mixed case javascript links",
+			svg:  `<svg><a href=" JavaScript:alert(1)"/></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><a/></svg>`,
+		},
+		{
+			name: "sanitize animations",
+			svg:  `<svg><a href="#ok"><animate attributeName="href" to="javascript:alert(1)"/><set attributeName="href" to="javascript:alert(1)"/></a></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><a href="#ok"/></svg>`,
+		},
+		{
+			name: "sanitize links with whitespace",
+			svg:  "<svg xmlns:xlink=\"http://www.w3.org/1999/xlink\"><a href=\"java\tscript:alert(1)\"/><a xlink:href=\"java&#10;script:alert(1)\"/><a href=\" &#13; javascript:alert(1)\"/></svg>",
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a/><a/><a/></svg>`,
+		},
+		{
+			name: "sanitize link schemes",
+			svg:  `<svg><a href="data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;"/><a href="vbscript:x"/><image href="data:image/svg+xml,x"/><image href="data:image/png;base64,AA=="/><a href="https://example.com/"/><a href="mailto:a@example.com"/><a href="docs/a.md"/></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><a/><a/><image/><image href="data:image/png;base64,AA=="/><a href="https://example.com/"/><a href="mailto:a@example.com"/><a href="docs/a.md"/></svg>`,
+		},
+		{
+			name: "sanitize embedded documents",
+			svg:  `<svg><iframe src="https://example.com/"/><embed src="x.swf"/><object data="x.html"><param name="a"/></object><SCRIPT>alert(1)</SCRIPT><g/></svg>`,
+			opts: SVGOptions{Sanitize: true},
+			want: `<svg><g/></svg>`,
+		},
+		{
+			name: "style as cdata",
+			svg:  `<svg><style><![CDATA[.a > .b { fill: red; }]]></style><style>.c { fill: url(#grad); }</style></svg>`,
+			opts: SVGOptions{Sanitize: true, PrefixIDs: true},
+			want: `<svg><style><![CDATA[.a > .b { fill: red; }]]></style><style>.c { fill: url(#r1234abcd-grad); }</style></svg>`,
+		},
+		{
+			name: "style with cdata end",
+			svg:  `<svg><style>.a::after { content: "]]&gt;"; }</style></svg>`,
+			want: `<svg><style><![CDATA[.a::after { content: "]]]]><![CDATA[>"; }]]></style></svg>`,
+		},
+		{
+			name: "sanitize and prefix",
+			svg:  `<svg><g id="a" onmouseover="x"><script/></g></svg>`,
+			opts: SVGOptions{PrefixIDs: true, Sanitize: true},
+			want: `<svg><g id="r1234abcd-a"/></svg>`,
+		},
+		{
+			name: "minify",
+			svg:  "<?xml version=\"1.0\"?>\n<!-- Generated -->\n<svg>\n  <g>\n    <text> a b </text>\n  </g>\n</svg>",
+			opts: SVGOptions{Minify: true},
+			want: `<svg><g><text> a b </text></g></svg>`,
+		},
+		{
+			name: "width",
+			svg:  `<svg width="200pt" height="100pt"><g/></svg>`,
+			opts: SVGOptions{Width: "100%"},
+			want: `<svg width="100%" height="100pt" viewBox="0 0 200 100"><g/></svg>`,
+		},
+		{
+			name: "dimensions keep viewBox",
+			svg:  `<svg width="200pt" height="100pt" viewBox="0 0 400 200"><svg width="1" height="1"/></svg>`,
+			opts: SVGOptions{Width: "300", Height: "150"},
+			want: `<svg width="300" height="150" viewBox="0 0 400 200"><svg width="1" height="1"/></svg>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProcessSVG([]byte(tt.svg), tt.opts, "r1234abcd-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ProcessSVG() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	regionEndMarker = "<!-- md-code-renderer:end -->"
+
+	// Written in place of the image until the code block is rendered
+	imagePlaceholder = "<!-- image here -->"
+)
+
+var (
+	// Match: <!-- md-code-renderer:begin mode=normal hash=db6d08bb -->
+	// Capture groups on the mode and the hash.
+	regionBeginMarkerRegexp = regexp.MustCompile(`^<!-- md-code-renderer:begin mode=(\S+) hash=(\w{8}) -->$`)
+
+	modeNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// builtinModeTemplates are the templates of the built-in modes, by mode name.
+var builtinModeTemplates = map[string]*template.Template{
+	"normal":          template.Must(template.New("normal").Parse("{{.Image}}\n\n{{.Code}}")),
+	"code-collapsed":  template.Must(template.New("code-collapsed").Parse("{{.Image}}\n\n<details><summary>Source</summary>\n\n{{.Code}}\n\n</details>")),
+	"image-collapsed": template.Must(template.New("image-collapsed").Parse("{{.Code}}\n\n<details><summary>Image</summary>\n\n{{.Image}}\n\n</details>")),
+	"code-hidden":     template.Must(template.New("code-hidden").Parse("{{.Image}}\n\n<!--\n{{.Code}}\n-->")),
+	"image-below":     template.Must(template.New("image-below").Parse("{{.Code}}\n\n{{.Image}}")),
+	"side-by-side":    template.Must(template.New("side-by-side").Parse("<table><tr><td>\n\n{{.Code}}\n\n</td><td>\n\n{{.Image}}\n\n</td></tr></table>")),
+	replaceMode:       template.Must(template.New(replaceMode).Parse("{{.Image}}\n\n{{.Code}}")),
+}
+
+// RenderTemplateManager contains methods to handle the templates for different rendering modes.
+type RenderTemplateManager struct{}
+
+// Parse reads the code block at codeBlockIndex into the chunk, along with
+// the layout rendered around it, if any. Layouts are delimited by marker
+// comments, so that they are recognized whatever their mode, and however
+// the whitespace within them has been reformatted:
+//
+//	<!-- md-code-renderer:begin mode=normal hash=db6d08bb -->
+//	![]()
+//
+//	```dot render
+//	```
+//	<!-- md-code-renderer:end -->
+//
+// Layouts written before marker comments were introduced are recognized by
+// the legacy templates of the built-in modes, and are rewritten with marker
+// comments. Either way, the previous layout is replaced in full when the mode
+// changes.
+func (m RenderTemplateManager) Parse(lines []string, codeBlockIndex int, chunk *Chunk) error {
+	content, codeBlockEndIndex, fenceStart, _, err := m.collectCodeBlock(lines, codeBlockIndex)
+	if err != nil {
+		return err
+	}
+	chunk.CodeBlockContent = content
+	chunk.CodeBlockFence = fenceStart
+	chunk.StartLineIndex = codeBlockIndex
+	chunk.EndLineIndex = codeBlockEndIndex
+
+	if begin, end, ok := findRegion(lines, codeBlockIndex, codeBlockEndIndex); ok {
+		m.readRegion(lines, begin, end, codeBlockIndex, codeBlockEndIndex, chunk)
+		return nil
+	}
+
+	// The legacy layouts of every mode are checked, since the mode may
+	// have changed since the code block was rendered. Layouts with more
+	// structure are checked first, since the image above a code block may
+	// also be part of them.
+	legacyLayouts := []func([]string, int, int, *Chunk) bool{
+		m.CodeCollapsed,
+		m.ImageCollapsed,
+		m.CodeHidden,
+		m.Normal,
+	}
+	for _, isRenderedBefore := range legacyLayouts {
+		if isRenderedBefore(lines, codeBlockIndex, codeBlockEndIndex, chunk) {
+			chunk.Lines = lines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+			return nil
+		}
+	}
+
+	m.setPlaceholder(codeBlockIndex, codeBlockEndIndex, chunk)
+	return nil
+}
+
+// ParseSource reads the layout rendered around the marker comment at
+// referenceIndex, which references the sidecar file that the chunk's code
+// block was moved to in the "replace" mode. The code block must have been
+// read into the chunk.
+func (m RenderTemplateManager) ParseSource(lines []string, referenceIndex int, chunk *Chunk) {
+	if begin, end, ok := findRegion(lines, referenceIndex, referenceIndex); ok {
+		m.readRegion(lines, begin, end, referenceIndex, referenceIndex, chunk)
+		return
+	}
+	m.setPlaceholder(referenceIndex, referenceIndex, chunk)
+}
+
+// setPlaceholder renders the template into the chunk, in place of the lines
+// from start to end. The image will be replaced later.
+func (m RenderTemplateManager) setPlaceholder(start int, end int, chunk *Chunk) {
+	chunk.StartLineIndex = start
+	chunk.EndLineIndex = end
+	chunk.RenderedHash = ""
+	chunk.setRenderedImage(imageLine{})
+	chunk.setTemplateLines(imagePlaceholder)
+}
+
+// findRegion returns the indexes of the marker comments delimiting the
+// layout rendered around a code block, if any.
+func findRegion(lines []string, codeBlockIndex int, codeBlockEndIndex int) (begin int, end int, ok bool) {
+	begin, end = -1, -1
+	for i := codeBlockIndex - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == regionEndMarker {
+			break
+		}
+		if regionBeginMarkerRegexp.MatchString(line) {
+			begin = i
+			break
+		}
+	}
+	for i := codeBlockEndIndex + 1; i < len(lines) && begin >= 0; i++ {
+		line := strings.TrimSpace(lines[i])
+		if regionBeginMarkerRegexp.MatchString(line) {
+			break
+		}
+		if line == regionEndMarker {
+			end = i
+			break
+		}
+	}
+	return begin, end, begin >= 0 && end >= 0
+}
+
+// readRegion reads the hash and the image of the layout between the marker
+// comments at begin and end. If the image is missing, the code block is
+// rendered again.
+func (m RenderTemplateManager) readRegion(lines []string, begin int, end int, codeBlockIndex int, codeBlockEndIndex int, chunk *Chunk) {
+	chunk.StartLineIndex = begin
+	chunk.EndLineIndex = end
+	chunk.Lines = lines[begin : end+1]
+	chunk.RenderedHash = regionBeginMarkerRegexp.FindStringSubmatch(strings.TrimSpace(lines[begin]))[2]
+	for i := begin + 1; i < end; i++ {
+		if i >= codeBlockIndex && i <= codeBlockEndIndex {
+			continue
+		}
+		if image, ok := chunk.parseImageLine(lines[i]); ok {
+			chunk.ImageRelativeLineIndex = i - begin
+			chunk.setRenderedImage(image)
+			return
+		}
+	}
+	chunk.RenderedHash = ""
+	chunk.setRenderedImage(imageLine{})
+}
+
+// setTemplateLines replaces the chunk's lines with the output of its mode's
+// template, delimited by marker comments. The built-in modes write the image
+// with its caption. The image line is the first line containing the image.
+func (r *Chunk) setTemplateLines(image string) {
+	if _, ok := builtinModeTemplates[r.RenderOptions.Mode]; ok {
+		image = r.captioned(image)
+	}
+	if r.linkStyle().SelfClosing {
+		image = selfCloseVoidElements(image)
+	}
+	code := append([]string{r.CodeBlockFence}, r.CodeBlockContent...)
+	code = append(code, "```")
+	if r.RenderOptions.Mode == replaceMode && r.SourcePath != "" {
+		// The code block has been moved to its sidecar file
+		code = []string{r.sourceReference()}
+	}
+	var caption string
+	if r.IsFigure() {
+		caption = r.FigureCaption()
+	}
+	tmpl := r.ModeTemplate
+	if tmpl == nil {
+		// Chunks outside a document, e.g. in the pandoc filter, have no
+		// template. Their lines aren't written anywhere.
+		tmpl = builtinModeTemplates[defaultRenderMode]
+	}
+	output, err := executeModeTemplate(tmpl, ModeTemplateData{
+		Image:    image,
+		Code:     strings.Join(code, "\n"),
+		Caption:  caption,
+		Hash:     r.HashContent()[:8],
+		Language: r.Language,
+		Options:  r.RenderOptions,
+	})
+	if err != nil {
+		// Templates are checked upfront, so this is unexpected. Keep
+		// the image and the code block rather than losing either.
+		output = image + "\n\n" + strings.Join(code, "\n")
+	}
+
+	r.Lines = []string{fmt.Sprintf("<!-- md-code-renderer:begin mode=%s hash=%s -->", r.RenderOptions.Mode, r.HashContent()[:8])}
+	r.Lines = append(r.Lines, strings.Split(output, "\n")...)
+	r.Lines = append(r.Lines, regionEndMarker)
+	r.ImageRelativeLineIndex = 0
+	for i, line := range r.Lines {
+		if strings.Contains(line, image) {
+			r.ImageRelativeLineIndex = i
+			break
+		}
+	}
+}
+
+// refreshLayout writes the chunk's layout again around its existing image,
+// since the mode, the caption, the numbering of figures or the link style may
+// have changed without the image being rendered again. Layouts written before
+// marker comments were introduced are migrated this way.
+func (r *Chunk) refreshLayout() {
+	if image, ok := r.parseImageLine(r.Lines[r.ImageRelativeLineIndex]); ok {
+		r.setTemplateLines(r.rebuildImage(image))
+	}
+}
+
+// rebuildImage writes the image again in the chunk's link style. Inline SVGs
+// and <picture> elements are kept as they are.
+func (r *Chunk) rebuildImage(image imageLine) string {
+	if image.inline == "svg" || image.dark {
+		return image.raw
+	}
+	return r.buildImage(image.alt, image.title, image.link)
+}
+
+// Normal detects the legacy layout of the "normal" mode. The layout looks like:
+//
+//	![]()
+//
+//	```dot render
+//	```
+func (m RenderTemplateManager) Normal(lines []string, codeBlockIndex int, codeBlockEndIndex int, chunk *Chunk) (isRenderedBefore bool) {
+	// Check 2 lines above if the image has been rendered before
+	for i := 1; i <= 2; i++ {
+		idx := codeBlockIndex - i
+		if idx < 0 {
+			break
+		}
+		prevLine := lines[idx]
+		hasImage := m.checkForImage(chunk, prevLine, func() {
+			chunk.StartLineIndex = idx
+			chunk.ImageRelativeLineIndex = 0
+			m.readHashComment(chunk, prevLine)
+			isRenderedBefore = true
+		})
+		if hasImage {
+			break
+		}
+	}
+	return isRenderedBefore
+}
+
+// CodeCollapsed detects the legacy layout of the "code-collapsed" mode. The layout looks like:
+//
+//	![]()
+//
+//	<details><summary>Source</summary>
+//
+//	```dot render
+//	```
+//
+//	</details>
+func (m RenderTemplateManager) CodeCollapsed(lines []string, codeBlockIndex int, codeBlockEndIndex int, chunk *Chunk) (isRenderedBefore bool) {
+	closingDetailsTag := "</details>"
+	hasClosingDetailsTag := codeBlockEndIndex+2 < len(lines) && lines[codeBlockEndIndex+2] == closingDetailsTag
+	openingDetailsTag := "<details><summary>Source</summary>"
+	hasOpeningDetailsTag := codeBlockIndex-2 >= 0 && lines[codeBlockIndex-2] == openingDetailsTag
+	if !hasClosingDetailsTag || !hasOpeningDetailsTag || codeBlockIndex-4 < 0 {
+		return false
+	}
+	line := lines[codeBlockIndex-4]
+	return m.checkForImage(chunk, line, func() {
+		chunk.StartLineIndex = codeBlockIndex - 4
+		chunk.EndLineIndex = codeBlockEndIndex + 2
+		chunk.ImageRelativeLineIndex = 0
+		m.readHashComment(chunk, line)
+	})
+}
+
+// ImageCollapsed detects the legacy layout of the "image-collapsed" mode. The layout looks like:
+//
+//	```dot render
+//	```
+//
+//	<details><summary>Image</summary>
+//
+//	![]()
+//
+//	</details>
+func (m RenderTemplateManager) ImageCollapsed(lines []string, codeBlockIndex int, codeBlockEndIndex int, chunk *Chunk) (isRenderedBefore bool) {
+	openingDetailsTag := "<details><summary>Image</summary>"
+	hasOpeningDetailsTag := codeBlockEndIndex+2 < len(lines) && lines[codeBlockEndIndex+2] == openingDetailsTag
+	closingDetailsTag := "</details>"
+	hasClosingDetailsTag := codeBlockEndIndex+6 < len(lines) && lines[codeBlockEndIndex+6] == closingDetailsTag
+	if !hasOpeningDetailsTag || !hasClosingDetailsTag {
+		return false
+	}
+	line := lines[codeBlockEndIndex+4]
+	return m.checkForImage(chunk, line, func() {
+		chunk.EndLineIndex = codeBlockEndIndex + 6
+		chunk.ImageRelativeLineIndex = (chunk.EndLineIndex - chunk.StartLineIndex) - 2
+		m.readHashComment(chunk, line)
+	})
+}
+
+// CodeHidden detects the legacy layout of the "code-hidden" mode. The layout looks like:
+//
+//	![]()
+//
+//	<!--
+//	```dot render
+//	```
+//	-->
+func (m RenderTemplateManager) CodeHidden(lines []string, codeBlockIndex int, codeBlockEndIndex int, chunk *Chunk) (isRenderedBefore bool) {
+	openingCommentTag := "<!--"
+	hasOpeningCommentTag := codeBlockIndex-1 > 0 && lines[codeBlockIndex-1] == openingCommentTag
+	closingCommentTag := "-->"
+	hasClosingCommentTag := codeBlockEndIndex+1 < len(lines) && lines[codeBlockEndIndex+1] == closingCommentTag
+	if !hasOpeningCommentTag || !hasClosingCommentTag || codeBlockIndex-3 <= 0 {
+		return false
+	}
+	line := lines[codeBlockIndex-3]
+	return m.checkForImage(chunk, line, func() {
+		chunk.StartLineIndex = codeBlockIndex - 3
+		chunk.EndLineIndex = codeBlockEndIndex + 1
+		chunk.ImageRelativeLineIndex = 0
+		m.readHashComment(chunk, line)
+	})
+}
+
+func (m RenderTemplateManager) collectCodeBlock(lines []string, codeBlockIndex int) (content []string, codeBlockEndIndex int, fenceStart string, fenceEnd string, err error) {
+	for i := codeBlockIndex + 1; i < len(lines); i++ {
+		line := lines[i]
+		if line == "```" {
+			return content, i, lines[codeBlockIndex], line, nil
+		}
+		content = append(content, line)
+	}
+	return nil, 0, "", "", errors.New("code block is unterminated")
+}
+
+func (m RenderTemplateManager) checkForImage(chunk *Chunk, line string, imageExistsFn func()) (imageExists bool) {
+	image, ok := chunk.parseImageLine(line)
+	if !ok {
+		return false
+	}
+	switch {
+	case image.inline != "":
+		// Inlined images always have a hash comment. They are
+		// recognized even if the chunk is no longer inlined, so that
+		// they are replaced rather than left behind.
+		if !renderedHashRegexp.MatchString(line) {
+			return false
+		}
+	case !chunk.HasHashComment:
+		// Without a hash comment, only images with auto-generated
+		// filenames are recognized, and the hash is read from the
+		// filename.
+		matches := renderedImageRegexp.FindStringSubmatch(image.link)
+		if len(matches) != 2 {
+			return false
+		}
+		chunk.RenderedHash = matches[1]
+	case !renderedHashRegexp.MatchString(line) && !isRecordedImage(chunk.DocumentName, image.link):
+		// Otherwise the image must have a hash comment, or be recorded
+		// in the manifest, so that images placed above the code block
+		// by hand are left alone.
+		return false
+	}
+	chunk.setRenderedImage(image)
+	imageExistsFn()
+	return true
+}
+
+// setRenderedImage records the image that was previously rendered for the
+// chunk.
+func (r *Chunk) setRenderedImage(image imageLine) {
+	r.RenderedFileName = image.link
+	r.RenderedDarkVariant = image.dark
+	r.RenderedInline = image.inline
+	r.RenderedAlt = image.alt
+	r.RenderedTitle = image.title
+}
+
+// imageLine is an image written by the templates.
+type imageLine struct {
+	raw    string // The image as written, without its caption
+	alt    string
+	title  string
+	link   string // Empty for inline SVGs
+	dark   bool   // Whether the image has a dark variant
+	inline string // How the image is embedded, if at all
+}
+
+// parseImageLine parses an image in any of the forms written by the
+// templates: an image in the chunk's link style or any of the built-in link
+// styles, an <img> or <picture> element, or an inline SVG, optionally with a
+// caption.
+func (r *Chunk) parseImageLine(line string) (image imageLine, ok bool) {
+	line = stripCaption(openVoidElements(strings.TrimSuffix(line, renderedHashSuffix(line))))
+	if raw := inlineSVGRegexp.FindString(line); raw != "" {
+		return imageLine{raw: raw, inline: "svg"}, true
+	}
+	if matches := pictureImageRegexp.FindStringSubmatch(line); len(matches) == 4 {
+		image.raw = matches[0]
+		image.alt, image.link, image.title = html.UnescapeString(matches[1]), html.UnescapeString(matches[2]), html.UnescapeString(matches[3])
+		image.dark = true
+	} else if matches := htmlImageRegexp.FindStringSubmatch(line); len(matches) == 4 {
+		image.raw = matches[0]
+		image.alt, image.link, image.title = html.UnescapeString(matches[1]), html.UnescapeString(matches[2]), html.UnescapeString(matches[3])
+	} else if linked, ok := parseLinkedImage(line, r.linkStyle()); ok {
+		image.raw = line
+		image.alt, image.link, image.title = linked.Alt, linked.Link, linked.Title
+	} else {
+		return image, false
+	}
+	if strings.HasPrefix(image.link, "data:") {
+		image.inline = "data-uri"
+	}
+	return image, true
+}
+
+func (m RenderTemplateManager) readHashComment(chunk *Chunk, line string) (hasHash bool) {
+	// Only check for the hash comment if the filename may not contain
+	// the hash. Otherwise the hash is contained in the auto-generated
+	// filename instead.
+	if !chunk.HasHashComment {
+		return
+	}
+	matches := renderedHashRegexp.FindStringSubmatch(line)
+	if len(matches) == 2 {
+		chunk.RenderedHash = matches[1]
+		return true
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testCode  = "digraph { a -> b }"
+	testHash  = "82682d8f229ac783001529cc84b0b85b" // Hash of testCode
+	testImage = "![Graphviz diagram](render-" + testHash + ".svg)"
+)
+
+func TestFindRegion(t *testing.T) {
+	begin := "<!-- md-code-renderer:begin mode=normal hash=82682d8f -->"
+	end := "<!-- md-code-renderer:end -->"
+	tests := []struct {
+		name              string
+		lines             []string
+		codeBlockIndex    int
+		codeBlockEndIndex int
+		wantBegin         int
+		wantEnd           int
+		wantOK            bool
+	}{
+		{
+			name:           "region",
+			lines:          []string{begin, "![]()", "", "```dot render", "```", end},
+			codeBlockIndex: 3, codeBlockEndIndex: 4,
+			wantBegin: 0, wantEnd: 5, wantOK: true,
+		},
+		{
+			name:           "indented markers",
+			lines:          []string{"  " + begin, "```dot render", "```", end + "  "},
+			codeBlockIndex: 1, codeBlockEndIndex: 2,
+			wantBegin: 0, wantEnd: 3, wantOK: true,
+		},
+		{
+			name:           "no markers",
+			lines:          []string{"![]()", "", "```dot render", "```"},
+			codeBlockIndex: 2, codeBlockEndIndex: 3,
+			wantBegin: -1, wantEnd: -1,
+		},
+		{
+			name:           "no end marker",
+			lines:          []string{begin, "```dot render", "```", ""},
+			codeBlockIndex: 1, codeBlockEndIndex: 2,
+			wantBegin: 0, wantEnd: -1,
+		},
+		{
+			name:           "previous region",
+			lines:          []string{begin, "![]()", end, "", "```dot render", "```", end},
+			codeBlockIndex: 4, codeBlockEndIndex: 5,
+			wantBegin: -1, wantEnd: -1,
+		},
+		{
+			name:           "next region",
+			lines:          []string{begin, "```dot render", "```", begin, "![]()", end},
+			codeBlockIndex: 1, codeBlockEndIndex: 2,
+			wantBegin: 0, wantEnd: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBegin, gotEnd, ok := findRegion(tt.lines, tt.codeBlockIndex, tt.codeBlockEndIndex)
+			if gotBegin != tt.wantBegin || gotEnd != tt.wantEnd || ok != tt.wantOK {
+				t.Errorf("findRegion() = %d, %d, %v, want %d, %d, %v", gotBegin, gotEnd, ok, tt.wantBegin, tt.wantEnd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseChunksRegions(t *testing.T) {
+	tests := []struct {
+		name      string
+		layout    []string // Lines after a heading and a blank line
+		wantEnd   int      // Index of the chunk's last line, relative to the layout
+		wantImage int      // Index of the image line, relative to the layout
+	}{
+		{
+			name: "normal",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=normal hash=82682d8f -->",
+				testImage,
+				"",
+				"```dot render",
+				testCode,
+				"```",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 6, wantImage: 1,
+		},
+		{
+			name: "code-collapsed",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=code-collapsed hash=82682d8f -->",
+				testImage,
+				"",
+				"<details><summary>Source</summary>",
+				"",
+				"```dot render" + `{"mode": "code-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"</details>",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 10, wantImage: 1,
+		},
+		{
+			name: "image-collapsed",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=image-collapsed hash=82682d8f -->",
+				"```dot render" + `{"mode": "image-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"<details><summary>Image</summary>",
+				"",
+				testImage,
+				"",
+				"</details>",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 10, wantImage: 7,
+		},
+		{
+			name: "code-hidden",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=code-hidden hash=82682d8f -->",
+				testImage,
+				"",
+				"<!--",
+				"```dot render" + `{"mode": "code-hidden"}`,
+				testCode,
+				"```",
+				"-->",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 8, wantImage: 1,
+		},
+		{
+			name: "image-below",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=image-below hash=82682d8f -->",
+				"```dot render" + `{"mode": "image-below"}`,
+				testCode,
+				"```",
+				"",
+				testImage,
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 6, wantImage: 5,
+		},
+		{
+			name: "side-by-side",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=side-by-side hash=82682d8f -->",
+				"<table><tr><td>",
+				"",
+				"```dot render" + `{"mode": "side-by-side"}`,
+				testCode,
+				"```",
+				"",
+				"</td><td>",
+				"",
+				testImage,
+				"",
+				"</td></tr></table>",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 12, wantImage: 9,
+		},
+		{
+			name: "replace",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=replace hash=82682d8f -->",
+				testImage,
+				"",
+				`<!-- md-code-renderer:source diagram-82682d8f.dot dot render{"mode": "replace"} -->`,
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 4, wantImage: 1,
+		},
+		{
+			name: "mode changed",
+			layout: []string{
+				"<!-- md-code-renderer:begin mode=code-collapsed hash=82682d8f -->",
+				testImage,
+				"",
+				"<details><summary>Source</summary>",
+				"",
+				"```dot render" + `{"mode": "code-hidden"}`,
+				testCode,
+				"```",
+				"",
+				"</details>",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 10, wantImage: 1,
+		},
+		{
+			name: "reformatted",
+			layout: []string{
+				"  <!-- md-code-renderer:begin mode=normal hash=82682d8f -->",
+				"",
+				testImage,
+				"",
+				"",
+				"```dot render",
+				testCode,
+				"```",
+				"",
+				"<!-- md-code-renderer:end -->",
+			},
+			wantEnd: 9, wantImage: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The sidecar file of the "replace" mode
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "diagram-82682d8f.dot"), []byte(testCode+"\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunk := parseTestChunk(t, dir, tt.layout)
+			if chunk.StartLineIndex != 2 || chunk.EndLineIndex != tt.wantEnd+2 {
+				t.Errorf("chunk lines = %d-%d, want %d-%d", chunk.StartLineIndex, chunk.EndLineIndex, 2, tt.wantEnd+2)
+			}
+			if chunk.ImageRelativeLineIndex != tt.wantImage {
+				t.Errorf("ImageRelativeLineIndex = %d, want %d", chunk.ImageRelativeLineIndex, tt.wantImage)
+			}
+			if chunk.RenderedFileName != "render-"+testHash+".svg" {
+				t.Errorf("RenderedFileName = %q", chunk.RenderedFileName)
+			}
+			if chunk.ShouldRender() {
+				t.Error("ShouldRender() = true, want false")
+			}
+		})
+	}
+}
+
+func TestParseChunksLegacyLayouts(t *testing.T) {
+	tests := []struct {
+		name               string
+		layout             []string // Lines after a heading and a blank line
+		wantStart          int      // Index of the chunk's first line, relative to the layout
+		wantEnd            int      // Index of the chunk's last line, relative to the layout
+		wantImage          int      // Index of the image line, relative to the chunk
+		wantFileName       string
+		wantRenderedBefore bool
+		wantMigration      []string // Layout after processing, with marker comments
+	}{
+		{
+			name:      "normal",
+			layout:    []string{testImage, "", "```dot render", testCode, "```"},
+			wantStart: 0, wantEnd: 4, wantImage: 0,
+			wantFileName:       "render-" + testHash + ".svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=normal hash=82682d8f -->",
+				testImage,
+				"",
+				"```dot render",
+				testCode,
+				"```",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name:      "normal with hash comment",
+			layout:    []string{"![Graphviz diagram](custom.svg) <!-- hash:82682d8f -->", "", "```dot render" + `{"filename": "custom.svg"}`, testCode, "```"},
+			wantStart: 0, wantEnd: 4, wantImage: 0,
+			wantFileName:       "custom.svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=normal hash=82682d8f -->",
+				"![Graphviz diagram](custom.svg)",
+				"",
+				"```dot render" + `{"filename": "custom.svg"}`,
+				testCode,
+				"```",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name:      "normal without hash comment",
+			layout:    []string{"![Graphviz diagram](custom.svg)", "", "```dot render" + `{"filename": "custom.svg"}`, testCode, "```"},
+			wantStart: 2, wantEnd: 4, wantImage: 1,
+			wantRenderedBefore: false,
+		},
+		{
+			name: "code-collapsed",
+			layout: []string{
+				testImage,
+				"",
+				"<details><summary>Source</summary>",
+				"",
+				"```dot render" + `{"mode": "code-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"</details>",
+			},
+			wantStart: 0, wantEnd: 8, wantImage: 0,
+			wantFileName:       "render-" + testHash + ".svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=code-collapsed hash=82682d8f -->",
+				testImage,
+				"",
+				"<details><summary>Source</summary>",
+				"",
+				"```dot render" + `{"mode": "code-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"</details>",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name: "image-collapsed",
+			layout: []string{
+				"```dot render" + `{"mode": "image-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"<details><summary>Image</summary>",
+				"",
+				testImage,
+				"",
+				"</details>",
+			},
+			wantStart: 0, wantEnd: 8, wantImage: 6,
+			wantFileName:       "render-" + testHash + ".svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=image-collapsed hash=82682d8f -->",
+				"```dot render" + `{"mode": "image-collapsed"}`,
+				testCode,
+				"```",
+				"",
+				"<details><summary>Image</summary>",
+				"",
+				testImage,
+				"",
+				"</details>",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name: "code-hidden",
+			layout: []string{
+				testImage,
+				"",
+				"<!--",
+				"```dot render" + `{"mode": "code-hidden"}`,
+				testCode,
+				"```",
+				"-->",
+			},
+			wantStart: 0, wantEnd: 6, wantImage: 0,
+			wantFileName:       "render-" + testHash + ".svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=code-hidden hash=82682d8f -->",
+				testImage,
+				"",
+				"<!--",
+				"```dot render" + `{"mode": "code-hidden"}`,
+				testCode,
+				"```",
+				"-->",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name: "code-collapsed changed to normal",
+			layout: []string{
+				testImage,
+				"",
+				"<details><summary>Source</summary>",
+				"",
+				"```dot render",
+				testCode,
+				"```",
+				"",
+				"</details>",
+			},
+			wantStart: 0, wantEnd: 8, wantImage: 0,
+			wantFileName:       "render-" + testHash + ".svg",
+			wantRenderedBefore: true,
+			wantMigration: []string{
+				"<!-- md-code-renderer:begin mode=normal hash=82682d8f -->",
+				testImage,
+				"",
+				"```dot render",
+				testCode,
+				"```",
+				"<!-- md-code-renderer:end -->",
+			},
+		},
+		{
+			name:      "not rendered",
+			layout:    []string{"```dot render", testCode, "```"},
+			wantStart: 0, wantEnd: 2, wantImage: 1,
+			wantRenderedBefore: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := parseTestChunk(t, t.TempDir(), tt.layout)
+			if chunk.StartLineIndex != tt.wantStart+2 || chunk.EndLineIndex != tt.wantEnd+2 {
+				t.Errorf("chunk lines = %d-%d, want %d-%d", chunk.StartLineIndex, chunk.EndLineIndex, tt.wantStart+2, tt.wantEnd+2)
+			}
+			if chunk.ImageRelativeLineIndex != tt.wantImage {
+				t.Errorf("ImageRelativeLineIndex = %d, want %d", chunk.ImageRelativeLineIndex, tt.wantImage)
+			}
+			if chunk.RenderedFileName != tt.wantFileName {
+				t.Errorf("RenderedFileName = %q, want %q", chunk.RenderedFileName, tt.wantFileName)
+			}
+			if chunk.ShouldRender() == tt.wantRenderedBefore {
+				t.Errorf("ShouldRender() = %v, want %v", chunk.ShouldRender(), !tt.wantRenderedBefore)
+			}
+			if tt.wantMigration == nil {
+				return
+			}
+
+			output, err := Process(testDocument(tt.layout), Options{
+				Languages: []string{"dot"},
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					t.Fatal("layout was rendered again")
+					return "", nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testDocument(tt.wantMigration); output != want {
+				t.Errorf("Process() =\n%s\nwant\n%s", output, want)
+			}
+		})
+	}
+}
+
+// testDocument returns a document with the layout after a heading.
+func testDocument(layout []string) string {
+	return strings.Join(append([]string{"# Title", ""}, layout...), "\n")
+}
+
+// parseTestChunk parses the document in dir with the layout, and returns its
+// renderable chunk.
+func parseTestChunk(t *testing.T, dir string, layout []string) *Chunk {
+	t.Helper()
+	chunks, err := ParseChunks(testDocument(layout), Options{
+		Name:      filepath.Join(dir, "doc.md"),
+		Languages: []string{"dot"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range chunks {
+		if v.IsRenderable {
+			return v
+		}
+	}
+	t.Fatal("no renderable chunk")
+	return nil
+}
+
+// region wraps a layout in marker comments.
+func region(mode string, hash string, layout string) string {
+	return "<!-- md-code-renderer:begin mode=" + mode + " hash=" + hash + " -->\n" + layout + "\n<!-- md-code-renderer:end -->"
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// darkThemeArgs are the arguments that select a dark theme for each
+// language's renderer. Colors set in the code block take precedence.
+var darkThemeArgs = map[string][]string{
+	"dot": {
+		"-Gbgcolor=transparent",
+		"-Gcolor=white",
+		"-Gfontcolor=white",
+		"-Ncolor=white",
+		"-Nfontcolor=white",
+		"-Ecolor=white",
+		"-Efontcolor=white",
+	},
+	"plantuml": {"-darkmode"},
+	"pikchr":   {"--dark-mode"},
+}
+
+// Match: <picture><source media="(prefers-color-scheme: dark)" srcset="render-{hash}-dark.svg"><img alt="Alt text" src="render-{hash}.svg" title="Title"></picture>
+// Capture groups on the alt text, the link to the light image and the title.
+var pictureImageRegexp = regexp.MustCompile(`<picture><source media="\(prefers-color-scheme: dark\)" srcset=".*?"><img alt="(.*?)" src="(.*?)"(?: title="(.*?)")?></picture>`)
+
+// HasDarkVariant returns whether a dark variant of the chunk's image is
+// rendered, to be shown when the reader prefers a dark color scheme. Inline
+// SVGs don't have dark variants.
+func (r *Chunk) HasDarkVariant() bool {
+	if r.Inline() == "svg" {
+		return false
+	}
+	if r.RenderOptions.Dark != nil {
+		return *r.RenderOptions.Dark
+	}
+	return r.DefaultDark
+}
+
+// RenderDarkFormat runs the renderer for the chunk's language with a dark
+// theme, and returns the rendered image in format.
+func (r *Chunk) RenderDarkFormat(format string) (content []byte, err error) {
+	return r.renderFormat(format, true)
+}
+
+// SetPicture updates the chunk's lines to display the image at link, or the
+// image at darkLink when the reader prefers a dark color scheme.
+func (r *Chunk) SetPicture(link string, darkLink string) {
+	r.setImageLine(BuildPicture(r.AltText(), r.Title(), link, darkLink))
+}
+
+// DarkFileName returns the filename of the dark variant of an image.
+func DarkFileName(fileName string) string {
+	ext := path.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "-dark" + ext
+}
+
+// BuildPicture returns a <picture> element that displays the image at link,
+// or the image at darkLink when the reader prefers a dark color scheme. The
+// title is omitted if empty. The element is kept on a single line, so that it
+// takes the place of a markdown image.
+func BuildPicture(alt string, title string, link string, darkLink string) string {
+	var titleAttr string
+	if title != "" {
+		titleAttr = fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+	}
+	return fmt.Sprintf(`<picture><source media="(prefers-color-scheme: dark)" srcset="%s"><img alt="%s" src="%s"%s></picture>`, html.EscapeString(darkLink), html.EscapeString(alt), html.EscapeString(link), titleAttr)
+}
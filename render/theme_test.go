@@ -0,0 +1,143 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProcessDark(t *testing.T) {
+	const (
+		image   = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		picture = `<picture><source media="(prefers-color-scheme: dark)" srcset="render-82682d8f229ac783001529cc84b0b85b-dark.svg"><img alt="Graphviz diagram" src="render-82682d8f229ac783001529cc84b0b85b.svg"></picture>`
+	)
+	tests := []struct {
+		name        string
+		doc         string
+		dark        bool
+		want        string
+		wantRenders int
+	}{
+		{
+			name:        "dark",
+			doc:         "```dot render\ndigraph { a -> b }\n```",
+			dark:        true,
+			want:        region("normal", "82682d8f", picture+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name: "rendered before",
+			doc:  region("normal", "82682d8f", picture+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			dark: true,
+			want: region("normal", "82682d8f", picture+"\n\n```dot render\ndigraph { a -> b }\n```"),
+		},
+		{
+			name:        "dark turned on",
+			doc:         region("normal", "82682d8f", image+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			dark:        true,
+			want:        region("normal", "82682d8f", picture+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name:        "dark turned off",
+			doc:         region("normal", "82682d8f", picture+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			want:        region("normal", "82682d8f", image+"\n\n```dot render\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+		{
+			name: "dark option overrides default",
+			doc:  region("normal", "82682d8f", image+"\n\n```dot render{\"dark\": false}\ndigraph { a -> b }\n```"),
+			dark: true,
+			want: region("normal", "82682d8f", image+"\n\n```dot render{\"dark\": false}\ndigraph { a -> b }\n```"),
+		},
+		{
+			name:        "custom filename",
+			doc:         "```dot render{\"dark\": true, \"filename\": \"flow.svg\"}\ndigraph { a -> b }\n```",
+			want:        region("normal", "82682d8f", `<picture><source media="(prefers-color-scheme: dark)" srcset="flow-dark.svg"><img alt="Graphviz diagram" src="flow.svg"></picture>`+"\n\n```dot render{\"dark\": true, \"filename\": \"flow.svg\"}\ndigraph { a -> b }\n```"),
+			wantRenders: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renders int
+			opts := Options{
+				Languages: []string{"dot"},
+				Dark:      tt.dark,
+				RenderChunk: func(chunk *Chunk) (string, error) {
+					renders++
+					fileName := chunk.FileName()
+					if chunk.HasDarkVariant() {
+						chunk.SetPicture(fileName, DarkFileName(fileName))
+					} else {
+						chunk.SetImage(fileName)
+					}
+					return fileName, nil
+				},
+			}
+			got, err := Process(tt.doc, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Process() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if renders != tt.wantRenders {
+				t.Errorf("rendered %d times, want %d", renders, tt.wantRenders)
+			}
+
+			renders = 0
+			again, err := Process(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got || renders != 0 {
+				t.Errorf("Process() again =\n%s\nwith %d renders, want it unchanged", again, renders)
+			}
+		})
+	}
+}
+
+func TestChunkRenderDark(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake renderers are shell scripts")
+	}
+	// The fake renderer outputs its arguments
+	bin := t.TempDir()
+	err := os.WriteFile(filepath.Join(bin, "dot"), []byte("#!/bin/sh\ncat >/dev/null\necho \"$@\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	outputDir := t.TempDir()
+	chunk := NewChunk("dot", "digraph { a -> b }", RenderOptions{})
+	chunk.DefaultDark = true
+	chunk.Lines = []string{""}
+	_, err = chunk.Render(outputDir, "img/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fileName string
+		wantArgs string
+	}{
+		{"render-82682d8f229ac783001529cc84b0b85b.svg", "-Tsvg\n"},
+		{"render-82682d8f229ac783001529cc84b0b85b-dark.svg", "-Tsvg " + strings.Join(darkThemeArgs["dot"], " ") + "\n"},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join(outputDir, tt.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.wantArgs {
+			t.Errorf("%s rendered with %q, want %q", tt.fileName, b, tt.wantArgs)
+		}
+	}
+	want := `<picture><source media="(prefers-color-scheme: dark)" srcset="img/render-82682d8f229ac783001529cc84b0b85b-dark.svg"><img alt="Graphviz diagram" src="img/render-82682d8f229ac783001529cc84b0b85b.svg"></picture>`
+	if image := chunk.Lines[chunk.ImageRelativeLineIndex]; image != want {
+		t.Errorf("image = %s, want %s", image, want)
+	}
+}
@@ -0,0 +1,385 @@
+package render
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// WebP images are encoded losslessly (VP8L), since diagrams are mostly flat
+// colors and sharp edges. The encoder applies the subtract green transform and
+// backward references to the pixel on the left or above, and prefix codes
+// built from the image's histograms. Format:
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+const (
+	vp8lSignature      = 0x2f
+	vp8lMaxDimension   = 1 << 14
+	vp8lMaxCodeLength  = 15
+	vp8lMinMatchLength = 3
+	vp8lMaxMatchLength = 4096
+
+	vp8lNumLiterals      = 256
+	vp8lNumLengthCodes   = 24
+	vp8lNumDistanceCodes = 40
+
+	// Distance codes of the pixel above and the pixel on the left
+	vp8lDistanceAbove = 1
+	vp8lDistanceLeft  = 2
+)
+
+// vp8lCodeLengthOrder is the order in which the code lengths of the code
+// length code are written.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeWebP encodes the image as a lossless WebP.
+func encodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.Errorf("invalid webp dimensions %dx%d", width, height)
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	// Pixels as ARGB, with the subtract green transform applied
+	pixels := make([]uint32, width*height)
+	hasAlpha := false
+	for i := range pixels {
+		p := nrgba.Pix[i*4 : i*4+4]
+		r, g, b, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+		if a != 0xff {
+			hasAlpha = true
+		}
+		pixels[i] = a<<24 | ((r-g)&0xff)<<16 | g<<8 | (b-g)&0xff
+	}
+	symbols := vp8lBackwardReferences(pixels, width)
+
+	var bw vp8lBitWriter
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(boolBit(hasAlpha), 1)
+	bw.write(0, 3) // Version
+	bw.write(1, 1) // Transform present
+	bw.write(2, 2) // Subtract green
+	bw.write(0, 1) // No more transforms
+	bw.write(0, 1) // No color cache
+	bw.write(0, 1) // No meta prefix codes
+	vp8lEncodeImage(&bw, symbols)
+	data := bw.bytes()
+
+	// RIFF container, with the chunk padded to an even size
+	padding := len(data) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	data = append(data, make([]byte, padding)...)
+	_, err := w.Write(append(header, data...))
+	return errors.Wrap(err, "write webp")
+}
+
+// vp8lSymbol is a literal pixel, or a backward reference copying length
+// pixels from distanceCode.
+type vp8lSymbol struct {
+	pixel        uint32
+	length       int
+	distanceCode int
+}
+
+// vp8lBackwardReferences returns the pixels as symbols, replacing runs of
+// pixels that repeat the pixel on the left or the row above with backward
+// references.
+func vp8lBackwardReferences(pixels []uint32, width int) []vp8lSymbol {
+	var symbols []vp8lSymbol
+	for i := 0; i < len(pixels); {
+		left := vp8lMatchLength(pixels, i, 1)
+		above := vp8lMatchLength(pixels, i, width)
+		switch {
+		case above >= vp8lMinMatchLength && above >= left:
+			symbols = append(symbols, vp8lSymbol{length: above, distanceCode: vp8lDistanceAbove})
+			i += above
+		case left >= vp8lMinMatchLength:
+			symbols = append(symbols, vp8lSymbol{length: left, distanceCode: vp8lDistanceLeft})
+			i += left
+		default:
+			symbols = append(symbols, vp8lSymbol{pixel: pixels[i]})
+			i++
+		}
+	}
+	return symbols
+}
+
+// vp8lMatchLength returns how many pixels from i repeat the pixels distance
+// pixels before them.
+func vp8lMatchLength(pixels []uint32, i int, distance int) int {
+	if i < distance {
+		return 0
+	}
+	n := 0
+	for i+n < len(pixels) && n < vp8lMaxMatchLength && pixels[i+n] == pixels[i+n-distance] {
+		n++
+	}
+	return n
+}
+
+// vp8lEncodeImage writes the prefix codes of the symbols, then the symbols.
+func vp8lEncodeImage(bw *vp8lBitWriter, symbols []vp8lSymbol) {
+	// Histograms of green and lengths, red, blue, alpha and distances
+	histograms := [5][]int{
+		make([]int, vp8lNumLiterals+vp8lNumLengthCodes),
+		make([]int, vp8lNumLiterals),
+		make([]int, vp8lNumLiterals),
+		make([]int, vp8lNumLiterals),
+		make([]int, vp8lNumDistanceCodes),
+	}
+	for _, s := range symbols {
+		if s.length > 0 {
+			prefix, _, _ := vp8lPrefixEncode(s.length)
+			histograms[0][vp8lNumLiterals+prefix]++
+			prefix, _, _ = vp8lPrefixEncode(s.distanceCode)
+			histograms[4][prefix]++
+			continue
+		}
+		histograms[0][s.pixel>>8&0xff]++
+		histograms[1][s.pixel>>16&0xff]++
+		histograms[2][s.pixel&0xff]++
+		histograms[3][s.pixel>>24]++
+	}
+	var codes [5]vp8lPrefixCode
+	for i, histogram := range histograms {
+		codes[i] = newVP8LPrefixCode(histogram, vp8lMaxCodeLength)
+		codes[i].writeHeader(bw)
+	}
+
+	for _, s := range symbols {
+		if s.length > 0 {
+			prefix, extraBits, extra := vp8lPrefixEncode(s.length)
+			codes[0].writeSymbol(bw, vp8lNumLiterals+prefix)
+			bw.write(extra, extraBits)
+			prefix, extraBits, extra = vp8lPrefixEncode(s.distanceCode)
+			codes[4].writeSymbol(bw, prefix)
+			bw.write(extra, extraBits)
+			continue
+		}
+		codes[0].writeSymbol(bw, int(s.pixel>>8&0xff))
+		codes[1].writeSymbol(bw, int(s.pixel>>16&0xff))
+		codes[2].writeSymbol(bw, int(s.pixel&0xff))
+		codes[3].writeSymbol(bw, int(s.pixel>>24))
+	}
+}
+
+// vp8lPrefixEncode returns the prefix code of a length or distance code, and
+// its extra bits.
+func vp8lPrefixEncode(v int) (prefix int, extraBits uint, extra uint32) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	n := v - 1
+	highest := 0
+	for n>>(highest+1) != 0 {
+		highest++
+	}
+	second := n >> (highest - 1) & 1
+	extraBits = uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(n) & (1<<extraBits - 1)
+}
+
+// vp8lPrefixCode is a canonical prefix code.
+type vp8lPrefixCode struct {
+	lengths []int
+	codes   []uint32 // Bit-reversed, as they are written LSB first
+	single  bool     // Only one symbol is used, and it's written with no bits
+}
+
+// newVP8LPrefixCode returns the prefix code for the histogram, with code
+// lengths of at most maxLength.
+func newVP8LPrefixCode(histogram []int, maxLength int) vp8lPrefixCode {
+	lengths := make([]int, len(histogram))
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) == 1 {
+		lengths[used[0]] = 1
+		return vp8lPrefixCode{lengths: lengths, codes: make([]uint32, len(histogram)), single: true}
+	}
+
+	// Build a Huffman tree, flattening the histogram until it fits
+	counts := make([]int, len(histogram))
+	copy(counts, histogram)
+	for {
+		huffmanLengths(counts, used, lengths)
+		longest := 0
+		for _, symbol := range used {
+			longest = maxInt(longest, lengths[symbol])
+		}
+		if longest <= maxLength {
+			break
+		}
+		for _, symbol := range used {
+			counts[symbol] = (counts[symbol] + 1) / 2
+		}
+	}
+	return vp8lPrefixCode{lengths: lengths, codes: canonicalCodes(lengths)}
+}
+
+// huffmanLengths sets the code lengths of the used symbols, from a Huffman
+// tree of their counts.
+func huffmanLengths(counts []int, used []int, lengths []int) {
+	type node struct {
+		count       int
+		symbol      int
+		left, right *node
+	}
+	nodes := make([]*node, 0, len(used))
+	for _, symbol := range used {
+		nodes = append(nodes, &node{count: counts[symbol], symbol: symbol})
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		parent := &node{count: nodes[0].count + nodes[1].count, symbol: -1, left: nodes[0], right: nodes[1]}
+		nodes = append([]*node{parent}, nodes[2:]...)
+	}
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.symbol >= 0 {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(nodes[0], 0)
+}
+
+// canonicalCodes returns the bit-reversed canonical codes of the code
+// lengths.
+func canonicalCodes(lengths []int) []uint32 {
+	var counts [vp8lMaxCodeLength + 1]uint32
+	for _, length := range lengths {
+		counts[length]++
+	}
+	counts[0] = 0
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= vp8lMaxCodeLength; length++ {
+		code = (code + counts[length-1]) << 1
+		next[length] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		code := next[length]
+		next[length]++
+		for i := 0; i < length; i++ {
+			codes[symbol] = codes[symbol]<<1 | code>>i&1
+		}
+	}
+	return codes
+}
+
+// writeHeader writes the code lengths of the prefix code, run-length encoded
+// with the code length code.
+func (c vp8lPrefixCode) writeHeader(bw *vp8lBitWriter) {
+	bw.write(0, 1) // Normal code
+
+	// Code length symbols: 0-15 are lengths, 17 and 18 are runs of zeros
+	type codeLengthSymbol struct {
+		symbol    int
+		extraBits uint
+		extra     uint32
+	}
+	var symbols []codeLengthSymbol
+	for i := 0; i < len(c.lengths); {
+		if c.lengths[i] != 0 {
+			symbols = append(symbols, codeLengthSymbol{symbol: c.lengths[i]})
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(c.lengths) && c.lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			symbols = append(symbols, codeLengthSymbol{18, 7, uint32(run - 11)})
+		case run >= 3:
+			symbols = append(symbols, codeLengthSymbol{17, 3, uint32(run - 3)})
+		default:
+			run = 1
+			symbols = append(symbols, codeLengthSymbol{symbol: 0})
+		}
+		i += run
+	}
+	histogram := make([]int, len(vp8lCodeLengthOrder))
+	for _, s := range symbols {
+		histogram[s.symbol]++
+	}
+	codeLengthCode := newVP8LPrefixCode(histogram, 7)
+
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && codeLengthCode.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:n] {
+		bw.write(uint32(codeLengthCode.lengths[symbol]), 3)
+	}
+	bw.write(0, 1) // Code lengths of every symbol follow
+	for _, s := range symbols {
+		codeLengthCode.writeSymbol(bw, s.symbol)
+		bw.write(s.extra, s.extraBits)
+	}
+}
+
+func (c vp8lPrefixCode) writeSymbol(bw *vp8lBitWriter, symbol int) {
+	if c.single {
+		return
+	}
+	bw.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// vp8lBitWriter writes bits LSB first.
+type vp8lBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (bw *vp8lBitWriter) write(v uint32, n uint) {
+	bw.bits |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.nBits > 0 {
+		return append(bw.buf, byte(bw.bits))
+	}
+	return bw.buf
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		pixel  func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA { return color.NRGBA{0x12, 0x34, 0x56, 0xff} }},
+		{"flat", 64, 32, func(x, y int) color.NRGBA { return color.NRGBA{0xff, 0xff, 0xff, 0xff} }},
+		{"transparent", 16, 16, func(x, y int) color.NRGBA { return color.NRGBA{} }},
+		{"stripes", 40, 30, func(x, y int) color.NRGBA {
+			if x%7 < 3 {
+				return color.NRGBA{0, 0, 0, 0xff}
+			}
+			return color.NRGBA{0xff, 0x80, 0, 0x80}
+		}},
+		{"gradient", 300, 20, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x), uint8(y * 10), uint8(x + y), uint8(255 - x)} }},
+		{"noise", 97, 53, func() func(x, y int) color.NRGBA {
+			r := rand.New(rand.NewSource(1))
+			return func(x, y int) color.NRGBA {
+				return color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256))}
+			}
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					img.SetNRGBA(x, y, tt.pixel(x, y))
+				}
+			}
+			var b bytes.Buffer
+			err := encodeWebP(&b, img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := webp.Decode(&b)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Fatalf("bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+			}
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if want := img.NRGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVP8LPrefixEncode(t *testing.T) {
+	tests := []struct {
+		v         int
+		prefix    int
+		extraBits uint
+		extra     uint32
+	}{
+		{1, 0, 0, 0},
+		{4, 3, 0, 0},
+		{5, 4, 1, 0},
+		{6, 4, 1, 1},
+		{7, 5, 1, 0},
+		{9, 6, 2, 0},
+		{4096, 23, 10, 1023},
+	}
+	for _, tt := range tests {
+		prefix, extraBits, extra := vp8lPrefixEncode(tt.v)
+		if prefix != tt.prefix || extraBits != tt.extraBits || extra != tt.extra {
+			t.Errorf("vp8lPrefixEncode(%d) = %d, %d, %d, want %d, %d, %d", tt.v, prefix, extraBits, extra, tt.prefix, tt.extraBits, tt.extra)
+		}
+	}
+}
+
+func TestNewVP8LPrefixCodeLimitsLengths(t *testing.T) {
+	// Fibonacci counts give the deepest Huffman trees
+	histogram := make([]int, 30)
+	a, b := 1, 1
+	for i := range histogram {
+		histogram[i] = a
+		a, b = b, a+b
+	}
+	code := newVP8LPrefixCode(histogram, vp8lMaxCodeLength)
+	kraft := 0
+	for symbol, length := range code.lengths {
+		if length < 1 || length > vp8lMaxCodeLength {
+			t.Fatalf("length of symbol %d = %d", symbol, length)
+		}
+		kraft += 1 << (vp8lMaxCodeLength - length)
+	}
+	if kraft != 1<<vp8lMaxCodeLength {
+		t.Errorf("code is not complete: %d", kraft)
+	}
+}
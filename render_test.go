@@ -0,0 +1,277 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+)
+
+// processStdinString runs processStdin with input as stdin, and returns what
+// it wrote to stdout.
+func processStdinString(t *testing.T, input string, outputDir string) string {
+	t.Helper()
+	dir := t.TempDir()
+	stdinPath, stdoutPath := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout")
+	writeFile(t, stdinPath, input)
+	stdin, err := os.Open(stdinPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(stdoutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	defer func() { os.Stdin, os.Stdout = oldStdin, oldStdout }()
+	err = processStdin(RenderConfig{Languages: "dot", DefaultFormat: "svg", OutputDir: outputDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readFile(t, stdoutPath)
+}
+
+func TestProcessStdin(t *testing.T) {
+	const (
+		image = "![Graphviz diagram](render-82682d8f229ac783001529cc84b0b85b.svg)"
+		begin = "<!-- md-code-renderer:begin mode=normal hash=82682d8f -->\n"
+		end   = "\n<!-- md-code-renderer:end -->"
+	)
+	tests := []struct {
+		name      string
+		input     string
+		outputDir string
+		want      string
+		wantCalls int
+	}{
+		{
+			name:  "no code blocks",
+			input: "# Doc\n\nText\n",
+			want:  "# Doc\n\nText\n",
+		},
+		{
+			name:      "code block",
+			input:     "# Doc\n\n```dot render\ndigraph { a -> b }\n```\n",
+			want:      "# Doc\n\n" + begin + image + "\n\n```dot render\ndigraph { a -> b }\n```" + end + "\n",
+			wantCalls: 1,
+		},
+		{
+			name:      "code block on the first line",
+			input:     "```dot render\ndigraph { a -> b }\n```",
+			want:      begin + image + "\n\n```dot render\ndigraph { a -> b }\n```" + end,
+			wantCalls: 1,
+		},
+		{
+			name:      "output directory",
+			input:     "```dot render\ndigraph { a -> b }\n```",
+			outputDir: "assets",
+			want:      begin + "![Graphviz diagram](assets/render-82682d8f229ac783001529cc84b0b85b.svg)\n\n```dot render\ndigraph { a -> b }\n```" + end,
+			wantCalls: 1,
+		},
+		{
+			name:  "rendered before",
+			input: "# Doc\n\n" + begin + image + "\n\n```dot render\ndigraph { a -> b }\n```" + end + "\n",
+			want:  "# Doc\n\n" + begin + image + "\n\n```dot render\ndigraph { a -> b }\n```" + end + "\n",
+		},
+		{
+			name:  "legacy layout",
+			input: "# Doc\n\n" + image + "\n\n```dot render\ndigraph { a -> b }\n```\n",
+			want:  "# Doc\n\n" + begin + image + "\n\n```dot render\ndigraph { a -> b }\n```" + end + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeRenderers(t)
+			chdir(t, t.TempDir())
+			outputDir := tt.outputDir
+			if outputDir != "" {
+				os.Mkdir(outputDir, 0755)
+			}
+			got := processStdinString(t, tt.input, outputDir)
+			if got != tt.want {
+				t.Errorf("output =\n%s\nwant\n%s", got, tt.want)
+			}
+			if calls() != tt.wantCalls {
+				t.Errorf("renderer called %d times, want %d", calls(), tt.wantCalls)
+			}
+
+			// The output is stable when piped through again
+			if again := processStdinString(t, got, outputDir); again != got {
+				t.Errorf("output of second pass =\n%s\nwant\n%s", again, got)
+			}
+			if calls() != tt.wantCalls {
+				t.Errorf("second pass rendered again")
+			}
+		})
+	}
+}
+
+func TestResolveLinkPrefix(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		markdownDir string
+		outputDir   string
+		linkPrefix  string
+		want        string
+	}{
+		{"same directory", "docs", "docs", "", ""},
+		{"subdirectory", "docs", "docs/assets", "", "assets/"},
+		{"sibling directory", "docs", "assets", "", "../assets/"},
+		{"parent directory", "docs/guide", "docs", "", "../"},
+		{"absolute output directory", "docs", filepath.Join(wd, "assets"), "", "../assets/"},
+		{"explicit prefix", "docs", "assets", "/static/", "/static/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveLinkPrefix(tt.markdownDir, tt.outputDir, tt.linkPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveLinkPrefix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFileRelativeLinks(t *testing.T) {
+	const fileName = "render-82682d8f229ac783001529cc84b0b85b.svg"
+	tests := []struct {
+		name      string
+		outputDir string
+		wantLink  string
+		wantImage string // Path of the rendered image
+	}{
+		{"default", "", fileName, "docs/guide/" + fileName},
+		{"output directory", "assets", "../../assets/" + fileName, "assets/" + fileName},
+		{"output directory template", "{{.Dir}}/assets/{{.Stem}}", "assets/doc/" + fileName, "docs/guide/assets/doc/" + fileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRenderers(t)
+			chdir(t, t.TempDir())
+			filePath := filepath.Join("docs", "guide", "doc.md")
+			writeFile(t, filePath, "```dot render\ndigraph { a -> b }\n```")
+
+			err := processFile(filePath, RenderConfig{Languages: "dot", DefaultFormat: "svg", OutputDir: tt.outputDir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "<!-- md-code-renderer:begin mode=normal hash=82682d8f -->\n![Graphviz diagram](" + tt.wantLink + ")\n\n```dot render\ndigraph { a -> b }\n```\n<!-- md-code-renderer:end -->"
+			if got := readFile(t, filePath); got != want {
+				t.Errorf("file =\n%s\nwant\n%s", got, want)
+			}
+			if _, err := os.Stat(filepath.FromSlash(tt.wantImage)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResolveOutputDir(t *testing.T) {
+	tests := []struct {
+		name      string
+		filePath  string
+		outputDir string
+		want      string
+		wantErr   bool
+	}{
+		{name: "default", filePath: "docs/guide.md", want: "docs"},
+		{name: "default without directory", filePath: "guide.md", want: "."},
+		{name: "fixed", filePath: "docs/guide.md", outputDir: "assets", want: "assets"},
+		{name: "template", filePath: "docs/guide.md", outputDir: "{{.Dir}}/assets/{{.Stem}}", want: "docs/assets/guide"},
+		{name: "template with name", filePath: "docs/guide.md", outputDir: "assets/{{.Name}}", want: "assets/guide.md"},
+		{name: "template without directory", filePath: "guide.md", outputDir: "{{.Dir}}/assets", want: "assets"},
+		{name: "unknown field", filePath: "docs/guide.md", outputDir: "{{.Bogus}}", wantErr: true},
+		{name: "invalid template", filePath: "docs/guide.md", outputDir: "{{.Dir", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveOutputDir(filepath.FromSlash(tt.filePath), tt.outputDir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveOutputDir() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("resolveOutputDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFileInputSetFigures(t *testing.T) {
+	fakeRenderers(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "index.md", "<!-- md-code-renderer:figures all -->")
+	writeFile(t, "docs/guide.md", "# Usage\n\n```dot render{\"caption\": \"Flow\", \"id\": \"flow\"}\ndigraph { a -> b }\n```")
+	cfg := RenderConfig{
+		Languages:     "dot",
+		DefaultFormat: "svg",
+		OutputDir:     "assets",
+		InputFiles:    []string{"index.md", filepath.Join("docs", "guide.md")},
+	}
+	for _, v := range cfg.InputFiles {
+		err := processFile(v, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "<!-- md-code-renderer:figures all -->\n\n" +
+		"| Figure | Section | Thumbnail |\n" +
+		"| --- | --- | --- |\n" +
+		"| [Figure 1: Flow](docs/guide.md#fig-flow) | [docs/guide.md: Usage](docs/guide.md#usage) | <img src=\"assets/render-82682d8f229ac783001529cc84b0b85b.svg\" alt=\"\" width=\"120\"> |\n\n" +
+		"<!-- /md-code-renderer:figures -->"
+	if got := readFile(t, "index.md"); got != want {
+		t.Errorf("index.md =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProcessFileFormats(t *testing.T) {
+	tests := []struct {
+		name      string
+		formats   []string
+		options   string
+		wantFiles []string
+	}{
+		{"default format", nil, "", []string{"render-82682d8f229ac783001529cc84b0b85b.svg"}},
+		{"filename", nil, `{"filename": "custom.png"}`, []string{"custom.png"}},
+		{"formats", []string{"svg", "png"}, "", []string{"render-82682d8f229ac783001529cc84b0b85b.png", "render-82682d8f229ac783001529cc84b0b85b.svg"}},
+		{"filename with formats", []string{"svg", "png"}, `{"filename": "custom.png"}`, []string{"custom.png", "custom.svg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeRenderers(t)
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "doc.md")
+			writeFile(t, filePath, "```dot render"+tt.options+"\ndigraph { a -> b }\n```")
+
+			err := processFile(filePath, RenderConfig{Languages: "dot", DefaultFormat: "svg", Formats: tt.formats})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, v := range entries {
+				if v.Name() != "doc.md" && v.Name() != render.ManifestFilename {
+					files = append(files, v.Name())
+				}
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+func NewServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a live preview of markdown files with rendered code blocks",
+		Long:  `Renders markdown files to HTML on the fly, and reloads the page in the browser whenever a file changes. Rendered images are kept in memory; input files are never modified.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: serveCmd,
+	}
+	addRenderFlags(cmd)
+	cmd.Flags().StringVar(&config.Serve.Addr, "addr", "localhost:8080", "Address to listen on")
+	cmd.Flags().DurationVar(&config.Watch.Debounce, "debounce", 200*time.Millisecond, "Time to wait for further changes to a file before reloading")
+	cmd.Flags().BoolVar(&config.Watch.Poll, "poll", false, "Poll files for changes instead of using filesystem notifications")
+	cmd.Flags().DurationVar(&config.Watch.PollInterval, "poll-interval", time.Second, "Interval between polls. Only used when polling.")
+	return cmd
+}
+
+func serveCmd(cmd *cobra.Command, args []string) error {
+	files, err := collectMarkdownFiles(args)
+	if err != nil {
+		return err
+	}
+	s := newPreviewServer()
+	w := newFileWatcher(config.Watch.Debounce)
+	w.dependencies = sourceFiles
+	for _, v := range files {
+		s.addFile(v)
+		w.track(v)
+	}
+	w.start(args, config.Watch.Poll, config.Watch.PollInterval)
+	go func() {
+		for filePath := range w.changes {
+			s.addFile(filePath)
+			s.broadcast(filePath)
+		}
+	}()
+
+	fmt.Printf("Serving preview of %d file(s) at http://%s/\n", len(files), config.Serve.Addr)
+	return http.ListenAndServe(config.Serve.Addr, s)
+}
+
+// previewServer renders markdown files to HTML on request. Rendered images
+// are cached in memory by the hash of their code block, so that only changed
+// code blocks are re-rendered on reload. Images are evicted from the cache
+// once no file's latest render references them.
+type previewServer struct {
+	markdown goldmark.Markdown
+
+	mu         sync.Mutex
+	files      map[string]string          // URL path -> file path
+	images     map[string][]byte          // URL path -> rendered image
+	referenced map[string]map[string]bool // File path -> URL paths of its images
+	clients    map[chan string]bool       // Connected event streams
+}
+
+func newPreviewServer() *previewServer {
+	return &previewServer{
+		markdown: goldmark.New(
+			goldmark.WithExtensions(extension.GFM),
+			goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+		),
+		files:      make(map[string]string),
+		images:     make(map[string][]byte),
+		referenced: make(map[string]map[string]bool),
+		clients:    make(map[chan string]bool),
+	}
+}
+
+func (s *previewServer) addFile(filePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[previewURLPath(filePath)] = filePath
+}
+
+func (s *previewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		s.serveIndex(w, r)
+	case r.URL.Path == "/_events":
+		s.serveEvents(w, r)
+	case strings.HasPrefix(r.URL.Path, "/_render/"):
+		s.serveImage(w, r)
+	default:
+		s.mu.Lock()
+		filePath, ok := s.files[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			// Serve other files referenced by the documents, such
+			// as images that are not rendered by us.
+			s.serveStatic(w, r)
+			return
+		}
+		s.servePage(w, r, filePath)
+	}
+}
+
+func (s *previewServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var urlPaths []string
+	for k := range s.files {
+		urlPaths = append(urlPaths, k)
+	}
+	s.mu.Unlock()
+	sort.Strings(urlPaths)
+
+	var body bytes.Buffer
+	body.WriteString("<ul>\n")
+	for _, v := range urlPaths {
+		fmt.Fprintf(&body, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(v), html.EscapeString(strings.TrimPrefix(v, "/")))
+	}
+	body.WriteString("</ul>\n")
+	s.writePage(w, "md-code-renderer", "", body.String())
+}
+
+func (s *previewServer) servePage(w http.ResponseWriter, r *http.Request, filePath string) {
+	var body bytes.Buffer
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	referenced := make(map[string]bool)
+	opts, err := config.Render.processOptions(filePath)
+	if err == nil {
+		opts.ForceRender = true
+		opts.RenderChunk = func(chunk *render.Chunk) (string, error) {
+			return s.renderChunk(chunk, referenced)
+		}
+		var content string
+		content, err = render.Process(string(b), opts)
+		if err == nil {
+			b = []byte(content)
+			s.evictImages(filePath, referenced)
+		}
+	}
+	if err != nil {
+		// Show the unprocessed document, so the page remains usable
+		// while the error is being fixed.
+		body.WriteString(buildRenderError(err) + "\n")
+	}
+	err = s.markdown.Convert(b, &body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writePage(w, filePath, previewURLPath(filePath), body.String())
+}
+
+// renderChunk renders the chunk into the in-memory image cache, recording the
+// URL paths of its images in referenced. Images are cached by their render
+// key, so that they are rendered again when their options change. Render
+// errors are shown in place of the image rather than failing the whole page.
+func (s *previewServer) renderChunk(chunk *render.Chunk, referenced map[string]bool) (string, error) {
+	fileName := chunk.FileName()
+	urlPath := "/_render/" + chunk.RenderKey(chunk.Format(), false) + "/" + fileName
+	referenced[urlPath] = true
+	err := s.renderImage(urlPath, chunk.RenderContent)
+	if err != nil {
+		chunk.Lines[chunk.ImageRelativeLineIndex] = buildRenderError(err)
+		return fileName, nil
+	}
+	if !chunk.HasDarkVariant() {
+		chunk.SetImage(urlPath)
+		return fileName, nil
+	}
+
+	darkURLPath := "/_render/" + chunk.RenderKey(chunk.Format(), true) + "/" + render.DarkFileName(fileName)
+	referenced[darkURLPath] = true
+	err = s.renderImage(darkURLPath, func() ([]byte, error) {
+		return chunk.RenderDarkFormat(chunk.Format())
+	})
+	if err != nil {
+		chunk.Lines[chunk.ImageRelativeLineIndex] = buildRenderError(err)
+		return fileName, nil
+	}
+	chunk.SetPicture(urlPath, darkURLPath)
+	return fileName, nil
+}
+
+// renderImage renders an image into the cache at urlPath, unless it is
+// already cached.
+func (s *previewServer) renderImage(urlPath string, renderFn func() ([]byte, error)) error {
+	s.mu.Lock()
+	_, ok := s.images[urlPath]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+	content, err := renderFn()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.images[urlPath] = content
+	s.mu.Unlock()
+	return nil
+}
+
+// evictImages records the images referenced by the latest render of the file,
+// and removes images that no file references anymore from the cache.
+func (s *previewServer) evictImages(filePath string, referenced map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.referenced[filePath] = referenced
+	for urlPath := range s.images {
+		inUse := false
+		for _, v := range s.referenced {
+			if v[urlPath] {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			delete(s.images, urlPath)
+		}
+	}
+}
+
+func (s *previewServer) serveImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, ok := s.images[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(r.URL.Path)))
+	w.Write(content)
+}
+
+// serveStatic serves files in the directories of the served markdown files,
+// such as images that are not rendered by us. Hidden files and directories
+// are not served.
+func (s *previewServer) serveStatic(w http.ResponseWriter, r *http.Request) {
+	filePath, ok := s.staticFilePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
+
+// staticFilePath returns the path of the file at urlPath, if it is in the
+// directory of a served markdown file, or one of its subdirectories.
+func (s *previewServer) staticFilePath(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, "/") {
+		return "", false
+	}
+	for _, v := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(v, ".") {
+			return "", false
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.files {
+		dir := filepath.Dir(v)
+		dirURLPath := strings.TrimSuffix(path.Clean(previewURLPath(dir)), "/") + "/"
+		if strings.HasPrefix(urlPath, dirURLPath) {
+			return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(urlPath, dirURLPath))), true
+		}
+	}
+	return "", false
+}
+
+// serveEvents streams the URL paths of changed files to the browser using
+// server-sent events.
+func (s *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	ch := make(chan string, 1)
+	s.mu.Lock()
+	s.clients[ch] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case urlPath := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", urlPath)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *previewServer) broadcast(filePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		select {
+		case ch <- previewURLPath(filePath):
+		default:
+		}
+	}
+}
+
+var previewPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 900px; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.5; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; }
+pre.render-error { background: #ffebe9; color: #82071e; white-space: pre-wrap; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+{{.Body}}
+<script>
+const page = {{.URLPath}};
+new EventSource("/_events").onmessage = (e) => {
+	if (page === "" || e.data === page) {
+		location.reload();
+	}
+};
+</script>
+</body>
+</html>
+`))
+
+func (s *previewServer) writePage(w http.ResponseWriter, title string, urlPath string, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := previewPageTemplate.Execute(w, struct {
+		Title   string
+		URLPath string
+		Body    template.HTML
+	}{title, urlPath, template.HTML(body)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Write page: %s\n", err)
+	}
+}
+
+// previewURLPath returns the URL path a markdown file is served at. Paths are
+// relative to the working directory where possible.
+func previewURLPath(filePath string) string {
+	if filepath.IsAbs(filePath) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, filePath); err == nil && !strings.HasPrefix(rel, "..") {
+				filePath = rel
+			}
+		}
+	}
+	return "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filePath)), "/")
+}
+
+func buildRenderError(err error) string {
+	return fmt.Sprintf("<pre class=\"render-error\">%s</pre>", html.EscapeString(err.Error()))
+}
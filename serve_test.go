@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewServer(t *testing.T) {
+	fakeRenderers(t)
+	config.Render = RenderConfig{Languages: "dot", DefaultFormat: "svg"}
+	defer func() { config.Render = RenderConfig{} }()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "doc.md")
+	content := "# Doc\n\n```dot render\ndigraph { a -> b }\n```\n"
+	writeFile(t, filePath, content)
+	writeFile(t, filepath.Join(dir, "img", "photo.svg"), "<svg/>")
+	writeFile(t, filepath.Join(dir, ".secret"), "secret")
+	s := newPreviewServer()
+	s.addFile(filePath)
+	pagePath := previewURLPath(filePath)
+
+	get := func(urlPath string) (int, string, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, urlPath, nil))
+		return rec.Code, rec.Header().Get("Content-Type"), rec.Body.String()
+	}
+	_, _, page := get(pagePath)
+	imagePath := regexp.MustCompile(`src="(/_render/[^"]+)"`).FindStringSubmatch(page)
+	if imagePath == nil {
+		t.Fatalf("page doesn't link to a rendered image:\n%s", page)
+	}
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{"index", "/", http.StatusOK, "text/html; charset=utf-8", `<a href="` + pagePath + `">`},
+		{"page", pagePath, http.StatusOK, "text/html; charset=utf-8", `new EventSource("/_events")`},
+		{"image", imagePath[1], http.StatusOK, "image/svg+xml", "<svg"},
+		{"unknown image", "/_render/0123/render-0123.svg", http.StatusNotFound, "", ""},
+		{"static file", path.Dir(pagePath) + "/img/photo.svg", http.StatusOK, "image/svg+xml", "<svg/>"},
+		{"hidden file", path.Dir(pagePath) + "/.secret", http.StatusNotFound, "", ""},
+		{"outside the served directories", path.Dir(path.Dir(pagePath)) + "/other.svg", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, contentType, body := get(tt.urlPath)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantContentType != "" && contentType != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, tt.wantContentType)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, body)
+			}
+		})
+	}
+
+	// The preview never modifies the files it serves
+	if got := readFile(t, filePath); got != content {
+		t.Errorf("file was modified:\n%s", got)
+	}
+}
+
+func TestPreviewServerRenderError(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "doc.md")
+	writeFile(t, filePath, "# Doc\n\n```dot render{\"mode\": \"bogus\"}\ndigraph { a -> b }\n```\n")
+	config.Render = RenderConfig{Languages: "dot", DefaultFormat: "svg"}
+	defer func() { config.Render = RenderConfig{} }()
+	s := newPreviewServer()
+	s.addFile(filePath)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, previewURLPath(filePath), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<pre class="render-error">`) || !strings.Contains(body, "digraph") {
+		t.Errorf("page doesn't show the error and the unprocessed document:\n%s", body)
+	}
+}
+
+func TestPreviewServerEvictsImages(t *testing.T) {
+	fakeRenderers(t)
+	config.Render = RenderConfig{Languages: "dot", DefaultFormat: "svg"}
+	defer func() { config.Render = RenderConfig{} }()
+	filePath := filepath.Join(t.TempDir(), "doc.md")
+	s := newPreviewServer()
+	s.addFile(filePath)
+
+	get := func(urlPath string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, urlPath, nil))
+		return rec.Code, rec.Body.String()
+	}
+	imagePathRegexp := regexp.MustCompile(`src="(/_render/[^"]+)"`)
+	writeFile(t, filePath, "```dot render\ndigraph { a -> b }\n```\n")
+	_, page := get(previewURLPath(filePath))
+	before := imagePathRegexp.FindStringSubmatch(page)
+	if before == nil {
+		t.Fatalf("page doesn't link to a rendered image:\n%s", page)
+	}
+
+	writeFile(t, filePath, "```dot render\ndigraph { a -> c }\n```\n")
+	_, page = get(previewURLPath(filePath))
+	after := imagePathRegexp.FindStringSubmatch(page)
+	if after == nil {
+		t.Fatalf("page doesn't link to a rendered image:\n%s", page)
+	}
+	if status, _ := get(before[1]); status != http.StatusNotFound {
+		t.Errorf("previous image status = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := get(after[1]); status != http.StatusOK {
+		t.Errorf("current image status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestPreviewServerRendersChangedOptions(t *testing.T) {
+	fakeRenderers(t)
+	config.Render = RenderConfig{Languages: "dot", DefaultFormat: "svg"}
+	defer func() { config.Render = RenderConfig{} }()
+	filePath := filepath.Join(t.TempDir(), "doc.md")
+	s := newPreviewServer()
+	s.addFile(filePath)
+
+	get := func(urlPath string) string {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, urlPath, nil))
+		return rec.Body.String()
+	}
+	imagePathRegexp := regexp.MustCompile(`src="(/_render/[^"]+)"`)
+	writeFile(t, filePath, "```dot render\ndigraph { a -> b }\n```\n")
+	get(previewURLPath(filePath))
+
+	// The code block is the same, but the image isn't
+	writeFile(t, filePath, "```dot render{\"width\": \"100%\"}\ndigraph { a -> b }\n```\n")
+	page := get(previewURLPath(filePath))
+	imagePath := imagePathRegexp.FindStringSubmatch(page)
+	if imagePath == nil {
+		t.Fatalf("page doesn't link to a rendered image:\n%s", page)
+	}
+	if image := get(imagePath[1]); !strings.Contains(image, `width="100%"`) {
+		t.Errorf("image =\n%s\nwant it rendered again with its width", image)
+	}
+}
+
+func TestPreviewServerEvents(t *testing.T) {
+	s := newPreviewServer()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	// Wait for the client to be registered before broadcasting
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client was not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.broadcast("docs/a.md")
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := "data: /docs/a.md\n"; line != want {
+		t.Errorf("event = %q, want %q", line, want)
+	}
+}
+
+func TestPreviewURLPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		filePath string
+		want     string
+	}{
+		{"a.md", "/a.md"},
+		{"./docs/../docs/a.md", "/docs/a.md"},
+		{filepath.Join(wd, "docs", "a.md"), "/docs/a.md"},
+		{"/elsewhere/a.md", "/elsewhere/a.md"},
+	}
+	for _, tt := range tests {
+		if got := previewURLPath(tt.filePath); got != tt.want {
+			t.Errorf("previewURLPath(%q) = %q, want %q", tt.filePath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/benjaminheng/md-code-renderer/render"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewWatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Watch markdown files and re-render code blocks when they change",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: watchCmd,
+	}
+	addRenderFlags(cmd)
+	cmd.Flags().DurationVar(&config.Watch.Debounce, "debounce", 200*time.Millisecond, "Time to wait for further changes to a file before re-rendering it")
+	cmd.Flags().BoolVar(&config.Watch.Poll, "poll", false, "Poll files for changes instead of using filesystem notifications")
+	cmd.Flags().DurationVar(&config.Watch.PollInterval, "poll-interval", time.Second, "Interval between polls. Only used when polling.")
+	return cmd
+}
+
+func watchCmd(cmd *cobra.Command, args []string) error {
+	w := newFileWatcher(config.Watch.Debounce)
+	w.dependencies = sourceFiles
+
+	// Render everything once on startup, so that the watcher starts from
+	// a consistent state.
+	files, err := collectMarkdownFiles(args)
+	if err != nil {
+		return err
+	}
+	config.Render.InputFiles = files
+	for _, v := range files {
+		w.track(v)
+		w.process(v)
+	}
+
+	w.start(args, config.Watch.Poll, config.Watch.PollInterval)
+	fmt.Printf("Watching %d file(s) for changes\n", len(files))
+
+	for filePath := range w.changes {
+		w.process(filePath)
+	}
+	return nil
+}
+
+// fileWatcher tracks a set of markdown files, and the files they reference,
+// and emits debounced change events for the markdown files. It remembers the
+// hash of each markdown file's content and its references after it was last
+// processed, so that write-backs from processFile do not trigger another
+// render.
+type fileWatcher struct {
+	debounce time.Duration
+	changes  chan string
+
+	// dependencies returns the files referenced by a markdown file, which
+	// are watched along with it. May be nil.
+	dependencies func(filePath string) ([]string, error)
+
+	mu       sync.Mutex
+	hashes   map[string]string          // File path -> hash of its content and its dependencies as last seen
+	timers   map[string]*time.Timer     // File path -> pending debounce timer
+	deps     map[string][]string        // File path -> files it references
+	owners   map[string]map[string]bool // Referenced file path -> markdown files referencing it
+	notifier *fsnotify.Watcher          // Set when filesystem notifications are used
+	dirs     map[string]bool            // Directories watched by the notifier
+}
+
+func newFileWatcher(debounce time.Duration) *fileWatcher {
+	return &fileWatcher{
+		debounce: debounce,
+		changes:  make(chan string),
+		hashes:   make(map[string]string),
+		timers:   make(map[string]*time.Timer),
+		deps:     make(map[string][]string),
+		owners:   make(map[string]map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+}
+
+func (w *fileWatcher) track(filePath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.hashes[filePath]; !ok {
+		w.hashes[filePath] = ""
+	}
+}
+
+func (w *fileWatcher) trackedFiles() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var files []string
+	for k := range w.hashes {
+		files = append(files, k)
+	}
+	return files
+}
+
+// watchedFiles returns the tracked files and the files they reference.
+func (w *fileWatcher) watchedFiles() []string {
+	files := w.trackedFiles()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for k := range w.owners {
+		if _, ok := w.hashes[k]; !ok {
+			files = append(files, k)
+		}
+	}
+	return files
+}
+
+// changed schedules change events for the file if it is tracked, and for the
+// markdown files referencing it. Returns whether any event was scheduled.
+func (w *fileWatcher) changed(filePath string) bool {
+	w.mu.Lock()
+	_, tracked := w.hashes[filePath]
+	var owners []string
+	for k := range w.owners[filePath] {
+		owners = append(owners, k)
+	}
+	w.mu.Unlock()
+	if tracked {
+		w.notify(filePath)
+	}
+	for _, v := range owners {
+		w.notify(v)
+	}
+	return tracked || len(owners) > 0
+}
+
+// notify schedules a change event for the file, resetting any pending event
+// so that rapid successive saves result in a single render.
+func (w *fileWatcher) notify(filePath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t, ok := w.timers[filePath]; ok {
+		t.Stop()
+	}
+	w.timers[filePath] = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, filePath)
+		w.mu.Unlock()
+		w.changes <- filePath
+	})
+}
+
+// process renders the file if its content or the content of the files it
+// references differs from what was last seen. Errors are logged rather than
+// returned, so that a broken diagram does not stop the watcher.
+func (w *fileWatcher) process(filePath string) {
+	hash, err := w.hashInputs(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", filePath, err)
+		return
+	}
+	w.mu.Lock()
+	unchanged := w.hashes[filePath] == hash
+	w.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	err = processFile(filePath, config.Render)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", filePath, err)
+	}
+
+	// Record the hash after processing, which includes any changes we
+	// wrote back to the file, or the files it references, ourselves.
+	w.updateDependencies(filePath)
+	hash, err = w.hashInputs(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", filePath, err)
+		return
+	}
+	w.mu.Lock()
+	w.hashes[filePath] = hash
+	w.mu.Unlock()
+}
+
+// updateDependencies records the files referenced by the markdown file, and
+// watches their directories if filesystem notifications are used.
+func (w *fileWatcher) updateDependencies(filePath string) {
+	if w.dependencies == nil {
+		return
+	}
+	deps, err := w.dependencies(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", filePath, err)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, v := range w.deps[filePath] {
+		delete(w.owners[v], filePath)
+		if len(w.owners[v]) == 0 {
+			delete(w.owners, v)
+		}
+	}
+	w.deps[filePath] = deps
+	for _, v := range deps {
+		if w.owners[v] == nil {
+			w.owners[v] = make(map[string]bool)
+		}
+		w.owners[v][filePath] = true
+		w.watchDirLocked(filepath.Dir(v))
+	}
+}
+
+// watchDirLocked watches the directory with the notifier, if one is used and
+// the directory isn't watched already. w.mu must be held.
+func (w *fileWatcher) watchDirLocked(dir string) {
+	if w.notifier == nil || w.dirs[dir] {
+		return
+	}
+	err := w.notifier.Add(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Watch error: %s\n", err)
+		return
+	}
+	w.dirs[dir] = true
+}
+
+// hashInputs returns the hash of the markdown file's content, and the content
+// of the files it references. Missing references are hashed as empty, so that
+// their reappearance is noticed.
+func (w *fileWatcher) hashInputs(filePath string) (string, error) {
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	w.mu.Lock()
+	deps := w.deps[filePath]
+	w.mu.Unlock()
+	for _, v := range deps {
+		depHash, _ := hashFile(v)
+		hash += v + depHash
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(hash))), nil
+}
+
+// start watches the tracked files for changes, using filesystem
+// notifications unless poll is set or notifications are unavailable.
+func (w *fileWatcher) start(paths []string, poll bool, pollInterval time.Duration) {
+	if !poll {
+		err := w.watchNotify(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filesystem notifications unavailable, falling back to polling: %s\n", err)
+			poll = true
+		}
+	}
+	if poll {
+		go w.watchPoll(pollInterval)
+	}
+}
+
+// watchNotify watches the directories containing the tracked files using
+// filesystem notifications. Directories are watched rather than the files
+// themselves, since many editors save by replacing the file.
+func (w *fileWatcher) watchNotify(paths []string) error {
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "create watcher")
+	}
+
+	// Directories given as arguments also pick up newly created files
+	watchedDirs := make(map[string]bool)
+	for _, v := range paths {
+		fileInfo, err := os.Stat(v)
+		if err != nil || !fileInfo.IsDir() {
+			continue
+		}
+		err = filepath.Walk(v, func(filePath string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				watchedDirs[filepath.Clean(filePath)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("walk %s", v))
+		}
+	}
+	dirs := make(map[string]bool)
+	for _, v := range w.watchedFiles() {
+		dirs[filepath.Dir(v)] = true
+	}
+	for k := range watchedDirs {
+		dirs[k] = true
+	}
+	for k := range dirs {
+		err := notifier.Add(k)
+		if err != nil {
+			notifier.Close()
+			return errors.Wrap(err, fmt.Sprintf("watch %s", k))
+		}
+	}
+	// Directories of files referenced later are watched as they appear
+	w.mu.Lock()
+	w.notifier = notifier
+	w.dirs = dirs
+	w.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-notifier.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				filePath := filepath.Clean(event.Name)
+				if w.changed(filePath) {
+					continue
+				}
+				if watchedDirs[filepath.Dir(filePath)] && isMarkdownFile(filePath) {
+					w.track(filePath)
+					w.notify(filePath)
+				}
+			case err, ok := <-notifier.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(os.Stderr, "Watch error: %s\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchPoll checks the modification time of each tracked file, and each file
+// they reference, at a fixed interval.
+func (w *fileWatcher) watchPoll(interval time.Duration) {
+	modTimes := make(map[string]time.Time)
+	for {
+		for _, v := range w.watchedFiles() {
+			fileInfo, err := os.Stat(v)
+			if err != nil {
+				continue
+			}
+			last, ok := modTimes[v]
+			modTimes[v] = fileInfo.ModTime()
+			if ok && !fileInfo.ModTime().Equal(last) {
+				w.changed(v)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// collectMarkdownFiles expands the given paths into a list of markdown files.
+// Directories are walked recursively.
+func collectMarkdownFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, v := range paths {
+		fileInfo, err := os.Stat(v)
+		if err != nil {
+			return nil, err
+		}
+		if !fileInfo.IsDir() {
+			files = append(files, filepath.Clean(v))
+			continue
+		}
+		err = filepath.Walk(v, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && isMarkdownFile(filePath) {
+				files = append(files, filepath.Clean(filePath))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("walk %s", v))
+		}
+	}
+	return files, nil
+}
+
+// sourceFiles returns the sidecar files holding the code blocks of the
+// markdown file, in the replace mode.
+func sourceFiles(filePath string) ([]string, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := config.Render.processOptions(filePath)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := render.ParseChunks(string(b), opts)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, chunk := range chunks {
+		if v := chunk.SourceFile(); v != "" {
+			files = append(files, filepath.Clean(v))
+		}
+	}
+	return files, nil
+}
+
+func isMarkdownFile(filePath string) bool {
+	switch filepath.Ext(filePath) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+func hashFile(filePath string) (string, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", md5.Sum(b)), nil
+}
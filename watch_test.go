@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFileWatcherNotify(t *testing.T) {
+	tests := []struct {
+		name          string
+		notifications []string
+		want          []string
+	}{
+		{"single save", []string{"a.md"}, []string{"a.md"}},
+		{"rapid saves", []string{"a.md", "a.md", "a.md"}, []string{"a.md"}},
+		{"two files", []string{"a.md", "b.md", "a.md"}, []string{"a.md", "b.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newFileWatcher(20 * time.Millisecond)
+			for _, v := range tt.notifications {
+				w.notify(v)
+			}
+			var got []string
+			timeout := time.After(200 * time.Millisecond)
+		loop:
+			for {
+				select {
+				case v := <-w.changes:
+					got = append(got, v)
+				case <-timeout:
+					break loop
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWatcherProcess(t *testing.T) {
+	calls := fakeRenderers(t)
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "doc.md")
+	config.Render = RenderConfig{Languages: "dot", DefaultFormat: "svg"}
+	defer func() { config.Render = RenderConfig{} }()
+
+	steps := []struct {
+		name      string
+		content   string // Written before processing, if set
+		wantCalls int
+	}{
+		{"initial render", "# Doc\n\n```dot render\ndigraph { a -> b }\n```\n", 1},
+		{"own write-back ignored", "", 1},
+		{"edit renders again", "# Doc\n\n```dot render\ndigraph { a -> c }\n```\n", 2},
+		{"unchanged save ignored", "-", 2},
+	}
+	w := newFileWatcher(time.Millisecond)
+	w.track(filePath)
+	for _, step := range steps {
+		switch step.content {
+		case "":
+		case "-":
+			// Save the file again without changing it
+			writeFile(t, filePath, readFile(t, filePath))
+		default:
+			writeFile(t, filePath, step.content)
+		}
+		w.process(filePath)
+		if got := calls(); got != step.wantCalls {
+			t.Fatalf("%s: renderer called %d times, want %d", step.name, got, step.wantCalls)
+		}
+	}
+}
+
+func TestCollectMarkdownFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, v := range []string{"a.md", "b.markdown", "c.txt", "sub/d.md", "sub/e.png"} {
+		writeFile(t, filepath.Join(dir, v), "")
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"file", []string{"a.md"}, []string{"a.md"}},
+		{"non-markdown file", []string{"c.txt"}, []string{"c.txt"}},
+		{"directory", []string{"."}, []string{"a.md", "b.markdown", "sub/d.md"}},
+		{"subdirectory", []string{"sub"}, []string{"sub/d.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths, want []string
+			for _, v := range tt.paths {
+				paths = append(paths, filepath.Join(dir, v))
+			}
+			for _, v := range tt.want {
+				want = append(want, filepath.Join(dir, v))
+			}
+			got, err := collectMarkdownFiles(paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("collectMarkdownFiles() = %v, want %v", got, want)
+			}
+		})
+	}
+}